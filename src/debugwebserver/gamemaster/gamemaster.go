@@ -180,5 +180,14 @@ func (h *Handler) stopgame(rw http.ResponseWriter, req *http.Request) {
 		fmt.Fprintln(rw, errorNotConnected)
 		return
 	}
-	rw.WriteHeader(http.StatusNotImplemented)
+	res, err := gmc.StopGame(context.Background(), &gs.StopGameRequest{
+		Reason: req.FormValue("gm-stopgame-reason"),
+	})
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(res)
 }
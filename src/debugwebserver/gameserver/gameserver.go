@@ -188,6 +188,7 @@ func (h *Handler) join(rw http.ResponseWriter, req *http.Request) {
 			Game: &messages.Game_NewPlayerFields_ChessFields{
 				ChessFields: &games.ChessNewPlayerFields{
 					WhiteTeam: req.FormValue("gs-join-team") != "false",
+					AnyTeam:   req.FormValue("gs-join-any-team") == "true",
 				},
 			},
 		},
@@ -235,7 +236,18 @@ func (h *Handler) status(rw http.ResponseWriter, req *http.Request) {
 		fmt.Fprintln(rw, errorNotConnected)
 		return
 	}
-	rw.WriteHeader(http.StatusNotImplemented)
+	res, err := gsc.Status(context.Background(), &gs.StatusRequest{})
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(struct {
+		Phase  string
+		Role   string
+		Status *gs.StatusResponse
+	}{res.GetPhase().String(), res.GetRole().String(), res})
 }
 
 func ctxWithPToken(t string) (context.Context, error) {
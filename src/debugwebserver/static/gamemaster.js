@@ -5,6 +5,7 @@ document.addEventListener('DOMContentLoaded', function(){
     formSetup('gm-connect-form', '/gamemaster/connect');
     formSetup('gm-connection-status-form', '/gamemaster/connectionstatus');
     formSetup('gm-initialize', '/gamemaster/initialize')
+    formSetup('gm-stopgame', '/gamemaster/stopgame');
 
     console.log('Gamemaster Loaded');
 });
\ No newline at end of file
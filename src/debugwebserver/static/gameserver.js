@@ -7,6 +7,7 @@ document.addEventListener('DOMContentLoaded', function(){
     /* Game Server stuff */
     formSetup('gs-connect-form', '/games/connect');
     formSetup('gs-connection-status-form', '/games/connectionstatus');
+    formSetup('gs-status-form', '/games/status');
     formSetup('gs-game-form', '/games/game');
     formSetup('gs-join-form', '/games/join');
     formSetup('gs-leave-form', '/games/leave');
@@ -0,0 +1,24 @@
+// Package backend starts and stops the masters of games created by the game registrar.
+package backend
+
+import (
+	"context"
+
+	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// Master is the running master of one game.
+type Master struct {
+	// Location of the game's GameServer service which players connect to.
+	Location string
+	// Master is a client of the game's GameServerMaster service.
+	Master gs.GameServerMasterClient
+}
+
+// Backend starts and stops the masters of games.
+type Backend interface {
+	// Start starts a master for the game and returns once it accepts RPCs. The master is not yet initialized.
+	Start(ctx context.Context, gameID string) (*Master, error)
+	// Stop stops the game's master, if running.
+	Stop(gameID string)
+}
@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/sambdavidson/community-chess/src/gameserver/registry"
+	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// LocalOpts contains initialization options for a Local backend.
+type LocalOpts struct {
+	// Binary is the path of the gameserver binary.
+	Binary string
+	// Args are passed to every gameserver along with the flags picking its game and ports, e.g. its TLS flags.
+	Args []string
+	// Host the gameservers are reached at. Defaults to localhost.
+	Host string
+	// TLSConfig is used to connect to the gameservers.
+	TLSConfig *tls.Config
+}
+
+// Local is a Backend running every master as a gameserver process on this machine.
+type Local struct {
+	opts LocalOpts
+
+	mux   sync.Mutex
+	procs map[string]*process
+}
+
+// process is a running gameserver.
+type process struct {
+	cmd  *exec.Cmd
+	conn *grpc.ClientConn
+	// Closed once the process has exited.
+	exited chan struct{}
+}
+
+// NewLocal returns a new Local backend.
+func NewLocal(opts LocalOpts) *Local {
+	if opts.Host == "" {
+		opts.Host = "localhost"
+	}
+	return &Local{
+		opts:  opts,
+		procs: map[string]*process{},
+	}
+}
+
+// Start spawns a gameserver running the master of the game and waits until it accepts RPCs or ctx is done.
+func (l *Local) Start(ctx context.Context, gameID string) (*Master, error) {
+	gamePort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	masterPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{
+		"--slave=false",
+		"--game_id=" + gameID,
+		"--game_port=" + strconv.Itoa(gamePort),
+		"--master_port=" + strconv.Itoa(masterPort),
+	}, l.opts.Args...)
+	cmd := exec.Command(l.opts.Binary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start gameserver: %v", err)
+	}
+	p := &process{
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		log.Printf("gameserver of game %s exited: %v", gameID, err)
+		close(p.exited)
+		l.remove(gameID, p)
+	}()
+
+	// Stop waiting for the master if its process exits first.
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.exited:
+			cancel()
+		case <-dialCtx.Done():
+		}
+	}()
+	opts := append(registry.WithGameID(gameID),
+		grpc.WithTransportCredentials(credentials.NewTLS(l.opts.TLSConfig)),
+		grpc.WithBlock(),
+	)
+	masterAddress := net.JoinHostPort(l.opts.Host, strconv.Itoa(masterPort))
+	if p.conn, err = grpc.DialContext(dialCtx, masterAddress, opts...); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("failed to dial gameserver at %s: %v", masterAddress, err)
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	select {
+	case <-p.exited:
+		p.conn.Close()
+		return nil, fmt.Errorf("gameserver of game %s exited while starting", gameID)
+	default:
+	}
+	l.procs[gameID] = p
+	return &Master{
+		Location: net.JoinHostPort(l.opts.Host, strconv.Itoa(gamePort)),
+		Master:   gs.NewGameServerMasterClient(p.conn),
+	}, nil
+}
+
+// Stop interrupts the gameserver running the game's master so it shuts down gracefully.
+func (l *Local) Stop(gameID string) {
+	l.mux.Lock()
+	p, ok := l.procs[gameID]
+	delete(l.procs, gameID)
+	l.mux.Unlock()
+	if !ok {
+		return
+	}
+	p.conn.Close()
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// Close stops every gameserver.
+func (l *Local) Close() {
+	l.mux.Lock()
+	ids := make([]string, 0, len(l.procs))
+	for id := range l.procs {
+		ids = append(ids, id)
+	}
+	l.mux.Unlock()
+	for _, id := range ids {
+		l.Stop(id)
+	}
+}
+
+// remove forgets the exited process, unless the game has since been given another.
+func (l *Local) remove(gameID string, p *process) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.procs[gameID] == p {
+		delete(l.procs, gameID)
+		p.conn.Close()
+	}
+}
+
+// freePort returns a port that is free to listen on. Another process may take it before the gameserver does.
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get free port: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,158 @@
+// Package main implements a server for the Game Registrar, the MC Server of docs/v1/api.md.
+package main
+
+/*
+go run .\src\gameregistrar --port=8060 --http_port=8061 --gameserver_binary=.\gameserver.exe --gameserver_args="--ca_bundle_path=... --master_cert_path=... --master_private_key_path=..." --debug
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/sambdavidson/community-chess/src/gameregistrar/backend"
+	"github.com/sambdavidson/community-chess/src/gameregistrar/server"
+	"github.com/sambdavidson/community-chess/src/lib/auth/grpcplayertokens"
+	"github.com/sambdavidson/community-chess/src/lib/debug"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/registrar"
+	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
+)
+
+var (
+	port                   = flag.Int("port", 8060, "port the GameRegistrar service accepts connections")
+	httpPort               = flag.Int("http_port", 8061, "port GET and POST /api/v1/games are served over HTTP")
+	caBundlePath           = flag.String("ca_bundle_path", "", "path to CA bundle for validating TLS connections")
+	tlsCertPath            = flag.String("tls_cert_path", "", "path to the game registrar TLS certificate")
+	tlsPKPath              = flag.String("tls_private_key_path", "", "path to the game registrar TLS private key")
+	playerRegistrarAddress = flag.String("player_registar_address", "playerregistrar:443", "address of the Player Registrar")
+	refreshInterval        = flag.Duration("refresh_interval", 10*time.Second, "how often listings are refreshed from their masters")
+
+	gameserverBinary = flag.String("gameserver_binary", "./gameserver", "path of the gameserver binary run for the master of every new game")
+	gameserverHost   = flag.String("gameserver_host", "localhost", "host the gameservers are reached at")
+	gameserverArgs   = flag.String("gameserver_args", "", "space separated flags passed to every gameserver, e.g. its TLS and player registrar flags")
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", *port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	config, err := tlsConfig()
+	if err != nil {
+		log.Fatalf("failed to build tls config: %v", err)
+	}
+	playersRegistrarConn, err := dialPlayerRegistrar(*playerRegistrarAddress, config)
+	if err != nil {
+		log.Fatalf("failed to connect to playerregistrar: %v", err)
+	}
+	defer playersRegistrarConn.Close()
+	// Anyone may list games, NewGame rejects players without a valid token itself.
+	auth := grpcplayertokens.NewPlayerAuthIngress(grpcplayertokens.PlayerAuthIngressArgs{
+		PlayersRegistrarClient: pr.NewPlayersRegistrarClient(playersRegistrarConn),
+	}).GetUnaryServerInterceptor(grpcplayertokens.Ignore)
+
+	local := backend.NewLocal(backend.LocalOpts{
+		Binary:    *gameserverBinary,
+		Args:      strings.Fields(*gameserverArgs),
+		Host:      *gameserverHost,
+		TLSConfig: config,
+	})
+	defer local.Close()
+	svr, err := server.New(&server.Opts{
+		Backend:         local,
+		RefreshInterval: *refreshInterval,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer svr.Close()
+
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(config)),
+		grpc.UnaryInterceptor(
+			middleware.ChainUnaryServer(
+				debug.UnaryServerInterceptor,
+				auth,
+			),
+		),
+	)
+	pb.RegisterGameRegistrarServer(s, svr)
+
+	http.Handle("/api/v1/games", http.StripPrefix("/api/v1", &server.Handler{Server: svr, Auth: auth}))
+	go func() {
+		log.Printf("Starting HTTP Server on Port: 0.0.0.0:%d\n", *httpPort)
+		if err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", *httpPort), nil); err != nil {
+			log.Printf("ERROR: HTTP Server failed to serve: %v\n", err)
+			s.GracefulStop()
+		}
+	}()
+	go func() {
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt)
+		<-signalChan
+		fmt.Print("\nReceived an interrupt, stopping services...\n")
+		s.GracefulStop()
+	}()
+
+	log.Printf("Starting listen of Game Registrar on port %v\n", *port)
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(*tlsCertPath, *tlsPKPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading X509KeyPair: %v", err)
+	}
+
+	caPool := x509.NewCertPool()
+	caPEM, err := ioutil.ReadFile(*caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading CA bundle file: %v", err)
+	}
+	if ok := caPool.AppendCertsFromPEM(caPEM); !ok {
+		return nil, fmt.Errorf("appending CA cert to cert pool not ok")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		RootCAs:      caPool,
+		ClientCAs:    caPool,
+	}, nil
+}
+
+func dialPlayerRegistrar(addr string, tlsConf *tls.Config) (*grpc.ClientConn, error) {
+	log.Printf("Connecting to playerregistrar at address: %s...", addr)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConf)))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	ok := conn.WaitForStateChange(ctx, connectivity.Connecting)
+	if !ok || conn.GetState() == connectivity.TransientFailure || conn.GetState() == connectivity.Shutdown {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to playerregistar, conn state: %v", conn.GetState())
+	}
+	return conn, nil
+}
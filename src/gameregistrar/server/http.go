@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/registrar"
+)
+
+const (
+	// playerTokenHeader is the HTTP header carrying the player's auth token.
+	playerTokenHeader = "X-Player-Token"
+	// playerTokenKey is the metadata key grpcplayertokens reads the player's auth token from.
+	playerTokenKey = "x-player-token"
+)
+
+// Handler serves the registrar over HTTP as GET and POST /games of the v1 API, see docs/v1/api.md.
+// Requests and responses are the JSON encoding of the GameRegistrar protos.
+type Handler struct {
+	Server *Server
+	// Auth validates the player token of every request, the same as it would for the GameRegistrar service.
+	Auth grpc.UnaryServerInterceptor
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if req.URL.Path != "/games" {
+		writeError(rw, status.Errorf(codes.NotFound, "unknown path %s", req.URL.Path))
+		return
+	}
+	ctx := metadata.NewIncomingContext(req.Context(), metadata.MD{})
+	if token := req.Header.Get(playerTokenHeader); token != "" {
+		ctx = metadata.NewIncomingContext(req.Context(), metadata.Pairs(playerTokenKey, token))
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		in := &pb.ListGamesRequest{}
+		for name, field := range map[string]*int32{"results_per_page": &in.ResultsPerPage, "page": &in.Page} {
+			v := req.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				writeError(rw, status.Errorf(codes.InvalidArgument, "%s is not a number: %q", name, v))
+				return
+			}
+			*field = int32(n)
+		}
+		h.call(ctx, rw, "ListGames", in, func(ctx context.Context, in interface{}) (interface{}, error) {
+			return h.Server.ListGames(ctx, in.(*pb.ListGamesRequest))
+		})
+	case http.MethodPost:
+		in := &pb.NewGameRequest{}
+		if err := jsonpb.Unmarshal(req.Body, in); err != nil {
+			writeError(rw, status.Errorf(codes.InvalidArgument, "unable to parse NewGameRequest: %v", err))
+			return
+		}
+		h.call(ctx, rw, "NewGame", in, func(ctx context.Context, in interface{}) (interface{}, error) {
+			return h.Server.NewGame(ctx, in.(*pb.NewGameRequest))
+		})
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// call handles the request as the GameRegistrar method and writes its response.
+func (h *Handler) call(ctx context.Context, rw http.ResponseWriter, method string, in interface{}, handler grpc.UnaryHandler) {
+	info := &grpc.UnaryServerInfo{
+		Server:     h.Server,
+		FullMethod: "/registrar.GameRegistrar/" + method,
+	}
+	res, err := h.Auth(ctx, in, info, handler)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	(&jsonpb.Marshaler{}).Marshal(rw, res.(proto.Message))
+}
+
+// writeError writes the GRPC status error with its closest HTTP status code.
+func writeError(rw http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		code = http.StatusConflict
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(struct {
+		Error string `json:"error"`
+	}{status.Convert(err).Message()})
+}
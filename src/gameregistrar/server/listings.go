@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// maxRefreshFailures is how many refreshes in a row may fail before a game is considered gone.
+const maxRefreshFailures = 3
+
+// refreshListings refreshes every listing from its master once per interval. Returns once stop is closed.
+func (s *Server) refreshListings(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.refreshAll()
+	}
+}
+
+// refreshAll refreshes the player counts of every listing. Finished games are removed from the catalog and their
+// masters stopped. A listing whose master cannot be reached keeps its last known counts, until it fails to be
+// refreshed several times in a row and is removed as well.
+func (s *Server) refreshAll() {
+	s.mux.Lock()
+	entries := make(map[string]*entry, len(s.games))
+	for id, e := range s.games {
+		entries[id] = e
+	}
+	s.mux.Unlock()
+
+	for id, e := range entries {
+		ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+		res, err := e.master.Master.Status(ctx, &gs.StatusRequest{})
+		cancel()
+		if err != nil {
+			log.Printf("unable to refresh listing of game %s: %v", id, err)
+			s.mux.Lock()
+			e.failures++
+			gone := e.failures >= maxRefreshFailures
+			s.mux.Unlock()
+			if gone {
+				s.remove(id, e)
+			}
+			continue
+		}
+		if res.GetPhase() == gs.StatusResponse_FINISHED {
+			log.Printf("game %s finished, removing it from the catalog", id)
+			s.remove(id, e)
+			continue
+		}
+		var players int64
+		for _, count := range res.GetTeamToCount() {
+			players += count
+		}
+		s.mux.Lock()
+		e.failures = 0
+		e.listing.PlayerCount = players
+		e.listing.TeamToCount = res.GetTeamToCount()
+		e.listing.RefreshTime = time.Now().UnixNano()
+		s.mux.Unlock()
+	}
+}
+
+// remove removes the game from the catalog and stops its master, unless the entry was already replaced.
+func (s *Server) remove(gameID string, e *entry) {
+	s.mux.Lock()
+	if s.games[gameID] != e {
+		s.mux.Unlock()
+		return
+	}
+	delete(s.games, gameID)
+	s.mux.Unlock()
+	s.backend.Stop(gameID)
+}
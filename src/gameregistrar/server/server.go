@@ -0,0 +1,254 @@
+// Package server implements the GameRegistrar service which keeps the catalog of games and creates new ones.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sambdavidson/community-chess/src/gameregistrar/backend"
+	"github.com/sambdavidson/community-chess/src/lib/auth/grpcplayertokens"
+	"github.com/sambdavidson/community-chess/src/lib/validation"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/registrar"
+	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+const (
+	// defaultRefreshInterval is how often listings are refreshed from their masters unless configured.
+	defaultRefreshInterval = 10 * time.Second
+	// masterCallTimeout bounds every call made to a master while refreshing listings.
+	masterCallTimeout = 5 * time.Second
+	// defaultResultsPerPage is the page size of listings if the request does not set one.
+	defaultResultsPerPage = 20
+	// maxResultsPerPage is the largest page size of listings.
+	maxResultsPerPage = 100
+	// startingFEN is the board every new chess game starts with.
+	startingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+)
+
+// Opts contains initialization options for a game registrar server.
+type Opts struct {
+	// Backend starts the master of every new game.
+	Backend backend.Backend
+	// RefreshInterval is how often listings are refreshed from their masters. Defaults to 10 seconds.
+	RefreshInterval time.Duration
+}
+
+// Server implements an in memory GameRegistrar.
+type Server struct {
+	backend backend.Backend
+
+	mux sync.Mutex
+	// Game ID to its entry in the catalog.
+	games map[string]*entry
+	// Number of games ever created, orders the catalog.
+	created int64
+
+	// Closed to stop refreshing listings.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// entry is a game in the catalog.
+type entry struct {
+	// Position of the game in the order games were created.
+	seq     int64
+	listing *pb.GameListing
+	master  *backend.Master
+	// Number of refreshes in a row that failed.
+	failures int
+}
+
+// New returns a new server that implements a game registrar and starts refreshing its listings.
+func New(opts *Opts) (*Server, error) {
+	if opts.Backend == nil {
+		return nil, fmt.Errorf("backend in options cannot be nil")
+	}
+	interval := opts.RefreshInterval
+	if interval == 0 {
+		interval = defaultRefreshInterval
+	}
+	s := &Server{
+		backend: opts.Backend,
+		games:   map[string]*entry{},
+		stop:    make(chan struct{}),
+	}
+	go s.refreshListings(interval)
+	return s, nil
+}
+
+// Close stops refreshing listings.
+func (s *Server) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// NewGame creates a game for the calling player. A master is started for the game and initialized with it.
+// The player is the game's creator, who alone may invite players to it if it is invite only.
+func (s *Server) NewGame(ctx context.Context, in *pb.NewGameRequest) (*pb.NewGameResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "missing player id from incoming context")
+	}
+	if err := validation.GameMetadata(in.GetMetadata()); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	state, history, err := newGameState(in.GetGameType(), in.GetMetadata(), now)
+	if err != nil {
+		return nil, err
+	}
+
+	gameID := uuid.New().String()
+	master, err := s.backend.Start(ctx, gameID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "unable to start a master for the game: %v", err)
+	}
+	g := &messages.Game{
+		Type:      in.GetGameType(),
+		Id:        gameID,
+		StartTime: now.UnixNano(),
+		Location:  master.Location,
+		Metadata:  in.GetMetadata(),
+		State:     state,
+		History:   history,
+	}
+	if err := validation.Game(g); err != nil {
+		s.backend.Stop(gameID)
+		return nil, err
+	}
+	access := &messages.GameAccess{
+		CreatorId:        pid,
+		AllowedPlayerIds: in.GetAllowedPlayerIds(),
+	}
+	if _, err := master.Master.Initialize(ctx, &gs.InitializeRequest{Game: g, Access: access}); err != nil {
+		s.backend.Stop(gameID)
+		return nil, err
+	}
+	log.Printf("created game %s at %s", gameID, master.Location)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.created++
+	s.games[gameID] = &entry{
+		seq: s.created,
+		listing: &pb.GameListing{
+			Game: &messages.Game{
+				Type:      g.GetType(),
+				Id:        g.GetId(),
+				StartTime: g.GetStartTime(),
+				Location:  g.GetLocation(),
+				Metadata:  g.GetMetadata(),
+			},
+			TeamToCount: map[string]int64{},
+			RefreshTime: now.UnixNano(),
+		},
+		master: master,
+	}
+	return &pb.NewGameResponse{Game: g}, nil
+}
+
+// ListGames lists a page of the publicly available games, newest first.
+func (s *Server) ListGames(ctx context.Context, in *pb.ListGamesRequest) (*pb.ListGamesResponse, error) {
+	perPage := int(in.GetResultsPerPage())
+	switch {
+	case perPage < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "results per page cannot be negative")
+	case perPage == 0:
+		perPage = defaultResultsPerPage
+	case perPage > maxResultsPerPage:
+		perPage = maxResultsPerPage
+	}
+	if in.GetPage() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "page cannot be negative")
+	}
+
+	listings := s.publicListings(func(*pb.GameListing) bool { return true })
+	start := int(in.GetPage()) * perPage
+	if start > len(listings) {
+		start = len(listings)
+	}
+	end := start + perPage
+	if end > len(listings) {
+		end = len(listings)
+	}
+	return &pb.ListGamesResponse{
+		Games: listings[start:end],
+		Time:  time.Now().UnixNano(),
+	}, nil
+}
+
+// SearchGames lists the publicly available games whose title contains the name ignoring case, newest first.
+func (s *Server) SearchGames(ctx context.Context, in *pb.SearchGamesRequest) (*pb.SearchGamesResponse, error) {
+	name := strings.ToLower(in.GetName())
+	if name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "missing name")
+	}
+	return &pb.SearchGamesResponse{
+		Games: s.publicListings(func(l *pb.GameListing) bool {
+			return strings.Contains(strings.ToLower(l.GetGame().GetMetadata().GetTitle()), name)
+		}),
+	}, nil
+}
+
+// publicListings returns copies of the listings of every open game matching keep, newest first.
+func (s *Server) publicListings(keep func(*pb.GameListing) bool) []*pb.GameListing {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	entries := []*entry{}
+	for _, e := range s.games {
+		if e.listing.GetGame().GetMetadata().GetVisibility() == messages.Game_Metadata_OPEN && keep(e.listing) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq > entries[j].seq
+	})
+	out := make([]*pb.GameListing, len(entries))
+	for i, e := range entries {
+		out[i] = proto.Clone(e.listing).(*pb.GameListing)
+	}
+	return out
+}
+
+// newGameState returns the state and history a new game of the type starts with.
+// If anything goes wrong returns a GRPC status error.
+func newGameState(t messages.Game_Type, m *messages.Game_Metadata, now time.Time) (*messages.Game_State, *messages.Game_History, error) {
+	switch t {
+	case messages.Game_CHESS:
+		if m.GetRules().GetChessRules() == nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "missing chess specific rules")
+		}
+		state := &games.ChessState{
+			BoardFen:       startingFEN,
+			RoundIndex:     1,
+			RoundStartTime: now.UnixNano(),
+			Details: &games.ChessState_Details{
+				PlayerIdToTeam: map[string]bool{},
+				PlayerToMove:   map[string]string{},
+			},
+		}
+		if tally := m.GetRules().GetVoteAppliedAfterTally(); tally != nil {
+			state.RoundEndTime = now.Add(time.Duration(tally.GetTimeoutSeconds()) * time.Second).UnixNano()
+		}
+		history := &games.ChessHistory{
+			StateHistory: []*games.ChessState{},
+		}
+		return &messages.Game_State{Game: &messages.Game_State_ChessState{ChessState: state}},
+			&messages.Game_History{Game: &messages.Game_History_ChessHistory{ChessHistory: history}},
+			nil
+	}
+	return nil, nil, status.Errorf(codes.InvalidArgument, "unknown game type: %v", t)
+}
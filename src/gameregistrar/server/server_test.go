@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/sambdavidson/community-chess/src/gameregistrar/backend"
+	"github.com/sambdavidson/community-chess/src/gameserver/game"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/registrar"
+	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// testMaster is a master client running the game implementation in process.
+type testMaster struct {
+	gs.GameServerMasterClient
+	impl game.Implementation
+
+	mux    sync.Mutex
+	status *gs.StatusResponse
+	err    error
+}
+
+func (m *testMaster) Initialize(ctx context.Context, in *gs.InitializeRequest, opts ...grpc.CallOption) (*gs.InitializeResponse, error) {
+	impl, ok := game.NewImplementation(in.GetGame().GetType())
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown game type: %v", in.GetGame().GetType())
+	}
+	m.impl = impl
+	return impl.Initialize(ctx, in)
+}
+
+func (m *testMaster) Status(ctx context.Context, in *gs.StatusRequest, opts ...grpc.CallOption) (*gs.StatusResponse, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.status, m.err
+}
+
+func (m *testMaster) setStatus(res *gs.StatusResponse, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.status, m.err = res, err
+}
+
+// testBackend starts a testMaster for every game.
+type testBackend struct {
+	mux     sync.Mutex
+	masters map[string]*testMaster
+	stopped map[string]bool
+}
+
+func (b *testBackend) Start(ctx context.Context, gameID string) (*backend.Master, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	m := &testMaster{}
+	b.masters[gameID] = m
+	return &backend.Master{
+		Location: "localhost:" + gameID,
+		Master:   m,
+	}, nil
+}
+
+func (b *testBackend) Stop(gameID string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.stopped[gameID] = true
+}
+
+func TestNewGame(t *testing.T) {
+	s, b := newTestServer(t)
+
+	if _, err := s.NewGame(context.Background(), &pb.NewGameRequest{Metadata: testMetadata("a")}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v creating a game without a player; want Unauthenticated", err)
+	}
+	bad := testMetadata("a")
+	bad.Rules.GameSpecific = nil
+	if _, err := s.NewGame(playerContext(), &pb.NewGameRequest{Metadata: bad}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v creating a game without chess rules; want InvalidArgument", err)
+	}
+
+	res, err := s.NewGame(playerContext(), &pb.NewGameRequest{Metadata: testMetadata("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.GetGame().GetId()
+	m := b.masters[id]
+	if m == nil || m.impl == nil {
+		t.Fatalf("no master was started and initialized for game %s", id)
+	}
+	if loc := res.GetGame().GetLocation(); loc != "localhost:"+id {
+		t.Errorf("got game at %q; want it at its master", loc)
+	}
+	stateRes, err := m.impl.State(context.Background(), &gs.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round := stateRes.GetState().GetChessState().GetRoundIndex(); round != 1 {
+		t.Errorf("master started on round %d; want 1", round)
+	}
+
+	bad = testMetadata("b")
+	bad.GetRules().GetChessRules().BalanceEnforcement = nil
+	if _, err := s.NewGame(playerContext(), &pb.NewGameRequest{Metadata: bad}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v creating a game the master refuses; want InvalidArgument", err)
+	}
+	listRes, err := s.ListGames(context.Background(), &pb.ListGamesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listRes.GetGames()) != 1 || len(b.stopped) != 1 || b.stopped[id] {
+		t.Errorf("got %d games listed and %v stopped; want the refused game's master stopped", len(listRes.GetGames()), b.stopped)
+	}
+}
+
+func TestListGames(t *testing.T) {
+	s, _ := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		m := testMetadata(fmt.Sprintf("game %d", i))
+		if i == 2 {
+			m.Visibility = messages.Game_Metadata_INVITE_ONLY
+		}
+		if _, err := s.NewGame(playerContext(), &pb.NewGameRequest{Metadata: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var titles []string
+	for page := int32(0); page < 3; page++ {
+		res, err := s.ListGames(context.Background(), &pb.ListGamesRequest{ResultsPerPage: 3, Page: page})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range res.GetGames() {
+			titles = append(titles, l.GetGame().GetMetadata().GetTitle())
+		}
+	}
+	want := []string{"game 4", "game 3", "game 1", "game 0"}
+	if fmt.Sprint(titles) != fmt.Sprint(want) {
+		t.Errorf("got games %v listed; want open games newest first %v", titles, want)
+	}
+
+	searchRes, err := s.SearchGames(context.Background(), &pb.SearchGamesRequest{Name: "GAME 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searchRes.GetGames()) != 1 || searchRes.GetGames()[0].GetGame().GetMetadata().GetTitle() != "game 3" {
+		t.Errorf("got %v searching for game 3", searchRes.GetGames())
+	}
+}
+
+func TestRefreshListings(t *testing.T) {
+	s, b := newTestServer(t)
+	ids := map[string]string{}
+	for _, title := range []string{"running", "finished", "gone"} {
+		res, err := s.NewGame(playerContext(), &pb.NewGameRequest{Metadata: testMetadata(title)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[title] = res.GetGame().GetId()
+	}
+	b.masters[ids["running"]].setStatus(&gs.StatusResponse{
+		Phase:       gs.StatusResponse_RUNNING,
+		TeamToCount: map[string]int64{"white": 2, "black": 1},
+	}, nil)
+	b.masters[ids["finished"]].setStatus(&gs.StatusResponse{Phase: gs.StatusResponse_FINISHED}, nil)
+	b.masters[ids["gone"]].setStatus(nil, status.Error(codes.Unavailable, "connection refused"))
+
+	for i := 0; i < maxRefreshFailures; i++ {
+		s.refreshAll()
+	}
+	res, err := s.ListGames(context.Background(), &pb.ListGamesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetGames()) != 1 || res.GetGames()[0].GetGame().GetId() != ids["running"] {
+		t.Fatalf("got %d games listed; want only the running game", len(res.GetGames()))
+	}
+	if l := res.GetGames()[0]; l.GetPlayerCount() != 3 || l.GetTeamToCount()["white"] != 2 {
+		t.Errorf("got %d players %v; want the master's 3 players", l.GetPlayerCount(), l.GetTeamToCount())
+	}
+	if !b.stopped[ids["finished"]] || !b.stopped[ids["gone"]] || b.stopped[ids["running"]] {
+		t.Errorf("got masters %v stopped; want the finished and gone games' masters stopped", b.stopped)
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *testBackend) {
+	t.Helper()
+	b := &testBackend{
+		masters: map[string]*testMaster{},
+		stopped: map[string]bool{},
+	}
+	s, err := New(&Opts{Backend: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, b
+}
+
+func playerContext() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-player-validated-id", "p1"))
+}
+
+func testMetadata(title string) *messages.Game_Metadata {
+	return &messages.Game_Metadata{
+		Title: title,
+		Rules: &messages.Game_Metadata_Rules{
+			VoteApplication: &messages.Game_Metadata_Rules_VoteAppliedAfterTally_{
+				VoteAppliedAfterTally: &messages.Game_Metadata_Rules_VoteAppliedAfterTally{
+					TimeoutSeconds: 60,
+				},
+			},
+			GameSpecific: &messages.Game_Metadata_Rules_ChessRules{
+				ChessRules: &games.ChessRules{
+					BalanceEnforcement: &games.ChessRules_TolerateDifference{
+						TolerateDifference: 10,
+					},
+				},
+			},
+		},
+	}
+}
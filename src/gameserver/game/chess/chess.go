@@ -2,6 +2,7 @@ package chess
 
 import (
 	"context"
+	"log"
 	"sync"
 	"time"
 
@@ -25,17 +26,52 @@ type Implementation struct {
 	endTime    time.Time
 	game       *ch.Game
 	roundIndex int32
+	// Version of the state shared by the master with its slaves.
+	version int64
+	// Set once the game has ended.
+	result *games.ChessGameResult
 
 	teamsMux sync.Mutex
 	// player ID to is_white_team
 	playerToTeam map[string]bool
 	teamToCount  map[bool]int64
+	// player ID to the time it joined in Nanos since EPOCH
+	playerToJoinTime map[string]int64
+	// Players moved by the rebalance after the latest departure.
+	rebalanced []string
+	// Player digest to player ID, see VotesFromTally. Built when first needed and reset once players join or leave.
+	digestToPlayer map[uint64]string
+	// Only tracked by the master when votes are applied immediately.
+	teamToLastMove map[bool]time.Time
+	// Last time each player switched teams, only tracked by the master.
+	playerToLastSwitch map[string]time.Time
 
-	moveMux sync.Mutex
-	// Move in the form of Algebraic Notation
+	moveMux        sync.Mutex
 	acceptingVotes bool
-	playerToMove   map[string]string
-	moveToCount    map[string]int64
+	// Moves are keyed by canonicalMove, their standard algebraic notation.
+	playerToMove map[string]string
+	moveToCount  map[string]int64
+	// Players that voted this round on a slave to the reporting slave's ID, only tracked by the master.
+	roundVoters map[string]string
+	// Sequence number of the latest change to the votes on this server. Never reset so GetVotes can tell cursors
+	// of earlier rounds apart.
+	voteSeq int64
+	// Sequence number the current round's votes started at.
+	roundVoteSeq int64
+	// Player to the sequence number of its latest vote or retraction this round.
+	playerToVoteSeq map[string]int64
+	// Players whose vote was retracted this round.
+	retracted map[string]bool
+	// Votes of round mergedRound merged from every server by the master at mergedTime, see UpdateTally.
+	mergedRound       int32
+	mergedMoveToCount map[string]int64
+	mergedTime        time.Time
+	// Only known by the master, slaves only know its hash.
+	selectionSeed     []byte
+	selectionSeedHash []byte
+
+	// Streams watching this game, see WatchGame.
+	watchers watchers
 
 	// Game proto stuff, the state is built dynamically.
 	metadata *messages.Game_Metadata
@@ -50,6 +86,7 @@ func (i *Implementation) resetWithState(s *games.ChessState) {
 	fen, _ := ch.FEN(s.GetBoardFen())
 	i.game = ch.NewGame(fen)
 	i.roundIndex = s.GetRoundIndex()
+	i.result = s.GetGameResult()
 	// TODO figure out if copying inputs is necessary
 	i.playerToTeam = s.GetDetails().GetPlayerIdToTeam()
 	if i.playerToTeam == nil {
@@ -59,6 +96,12 @@ func (i *Implementation) resetWithState(s *games.ChessState) {
 		true:  s.GetWhiteTeamCount(),
 		false: s.GetBlackTeamCount(),
 	}
+	i.playerToJoinTime = s.GetDetails().GetPlayerToJoinTime()
+	if i.playerToJoinTime == nil {
+		i.playerToJoinTime = map[string]int64{}
+	}
+	i.rebalanced = s.GetRebalancedPlayerIds()
+	i.digestToPlayer = nil
 	i.playerToMove = s.GetDetails().GetPlayerToMove()
 	if i.playerToMove == nil {
 		i.playerToMove = map[string]string{}
@@ -67,12 +110,13 @@ func (i *Implementation) resetWithState(s *games.ChessState) {
 	if i.moveToCount == nil {
 		i.moveToCount = map[string]int64{}
 	}
-	i.history = &games.ChessHistory{
-		StateHistory: []*games.ChessState{},
-	}
+	i.resetVoteSeqs()
+	i.selectionSeedHash = s.GetSelectionSeedHash()
+	i.roundVoters = map[string]string{}
 }
 
 // Initialize initializes this server to run the game defined in InitializeRequest.
+// A slave re-initializes after registering with a new master, keeping its votes if the round is unchanged.
 func (i *Implementation) Initialize(ctx context.Context, in *pb.InitializeRequest) (*pb.InitializeResponse, error) {
 	if err := validateChessRules(in.GetGame().GetMetadata().GetRules().GetChessRules()); err != nil {
 		return nil, err
@@ -81,17 +125,80 @@ func (i *Implementation) Initialize(ctx context.Context, in *pb.InitializeReques
 		return nil, err
 	}
 
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	sameRound := i.initialized && i.roundIndex == in.GetGame().GetState().GetChessState().GetRoundIndex()
+	playerToMove, moveToCount := i.playerToMove, i.moveToCount
+	playerToVoteSeq, retracted, roundVoteSeq := i.playerToVoteSeq, i.retracted, i.roundVoteSeq
 	i.resetWithState(in.GetGame().GetState().GetChessState())
+	if sameRound {
+		i.playerToMove, i.moveToCount = playerToMove, moveToCount
+		i.playerToVoteSeq, i.retracted, i.roundVoteSeq = playerToVoteSeq, retracted, roundVoteSeq
+		i.retractDepartedVotes()
+	}
+	i.version = in.GetGame().GetState().GetVersion()
 	if m := in.GetGame().GetMetadata(); m != nil {
 		i.metadata = m
 	}
+	i.history = &games.ChessHistory{
+		StateHistory: []*games.ChessState{},
+	}
+	i.teamToLastMove = map[bool]time.Time{}
+	i.playerToLastSwitch = map[string]time.Time{}
 	if h := in.GetGame().GetHistory().GetChessHistory(); h != nil {
 		i.history = h
+		i.replayHistory()
+	}
+	// Slaves are initialized with the master's state which already holds the selection seed hash.
+	if i.metadata.GetRules().GetVoteAppliedAfterTally().GetSelectionType() == messages.Game_Metadata_Rules_VoteAppliedAfterTally_PROBABILITY &&
+		len(i.selectionSeedHash) == 0 {
+		if err := i.newSelectionSeed(); err != nil {
+			return nil, err
+		}
 	}
+	i.acceptingVotes = i.result == nil
 	i.initialized = true
+	i.publish(pb.WatchGameResponse_SNAPSHOT, nil)
 	return &pb.InitializeResponse{}, nil
 }
 
+// replayHistory rebuilds the game by applying the move of every closed round from the history's first board, so
+// repetitions are still detected once the game is restored from a state that only holds the current board.
+// The game is kept as is if the moves cannot be replayed or do not lead to the current board.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) replayHistory() {
+	states := i.history.GetStateHistory()
+	if len(states) == 0 {
+		return
+	}
+	fen, err := ch.FEN(states[0].GetBoardFen())
+	if err != nil {
+		log.Printf("unable to replay the history: bad board of round %d: %v", states[0].GetRoundIndex(), err)
+		return
+	}
+	g := ch.NewGame(fen)
+	for _, s := range states {
+		m, err := (ch.AlgebraicNotation{}).Decode(g.Position(), s.GetResult().GetMove())
+		if err == nil {
+			err = g.Move(m)
+		}
+		if err != nil {
+			log.Printf("unable to replay the history: bad move %s of round %d: %v", s.GetResult().GetMove(), s.GetRoundIndex(), err)
+			return
+		}
+	}
+	if g.FEN() != i.game.FEN() {
+		log.Printf("unable to replay the history: moves lead to board %s; current board %s", g.FEN(), i.game.FEN())
+		return
+	}
+	i.game = g
+}
+
 // UpdateMetadata is called by GameServerMasters to update this slave's metadata.
 func (i *Implementation) UpdateMetadata(ctx context.Context, in *pb.UpdateMetadataRequest) (*pb.UpdateMetadataResponse, error) {
 	i.metadata = in.GetMetadata()
@@ -99,17 +206,44 @@ func (i *Implementation) UpdateMetadata(ctx context.Context, in *pb.UpdateMetada
 }
 
 // UpdateState is called by GameServerMasters to update this slave's state of the game.
+// Votes are collected separately by every server so this slave's votes are kept until the round changes,
+// except those of players that left which are retracted.
+// Stale versions are rejected as Aborted and skipped versions, unless resyncing, as OutOfRange.
 func (i *Implementation) UpdateState(ctx context.Context, in *pb.UpdateStateRequest) (*pb.UpdateStateResponse, error) {
-	validateChessState(in.GetState().GetChessState(), true)
+	if err := validateChessState(in.GetState().GetChessState(), true); err != nil {
+		return nil, err
+	}
 
 	i.gameMux.Lock()
 	i.teamsMux.Lock()
 	i.moveMux.Lock()
-	i.resetWithState(in.GetState().GetChessState())
-	i.moveMux.Unlock()
-	i.teamsMux.Unlock()
-	i.gameMux.Unlock()
+	defer i.gameMux.Unlock()
+	defer i.teamsMux.Unlock()
+	defer i.moveMux.Unlock()
 
+	v := in.GetState().GetVersion()
+	if v <= i.version {
+		return nil, status.Errorf(codes.Aborted, "stale state version %d; current version %d", v, i.version)
+	}
+	if v > i.version+1 && !in.GetResync() {
+		return nil, status.Errorf(codes.OutOfRange, "state version %d skips versions after %d, resync required", v, i.version)
+	}
+	i.version = v
+	oldRound, oldEndTime, oldResult := i.roundIndex, i.endTime, i.result
+	oldWhite, oldBlack := i.teamToCount[true], i.teamToCount[false]
+	sameRound := i.roundIndex == in.GetState().GetChessState().GetRoundIndex()
+	playerToMove, moveToCount := i.playerToMove, i.moveToCount
+	playerToVoteSeq, retracted, roundVoteSeq := i.playerToVoteSeq, i.retracted, i.roundVoteSeq
+	i.resetWithState(in.GetState().GetChessState())
+	if sameRound {
+		i.playerToMove, i.moveToCount = playerToMove, moveToCount
+		i.playerToVoteSeq, i.retracted, i.roundVoteSeq = playerToVoteSeq, retracted, roundVoteSeq
+		i.retractDepartedVotes()
+	}
+	if h := in.GetHistory().GetChessHistory(); h != nil {
+		i.history = h
+	}
+	i.publishStateChanges(oldRound, oldEndTime, oldResult, oldWhite, oldBlack)
 	return &pb.UpdateStateResponse{}, nil
 }
 
@@ -1,11 +1,17 @@
 package chess
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages"
 	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
@@ -22,49 +28,1273 @@ func TestInitialization(t *testing.T) {
 	}
 }
 
-func initializedDefaultGame() (*Implementation, *pb.InitializeResponse, error) {
-	c := &Implementation{}
+func TestCloseRound(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false})
+
+	state, err := c.CloseRound(context.TODO(), []*messages.Vote{
+		testVote(c, "w1", 1, "e4"),
+		testVote(c, "w2", 1, "d4"),
+		testVote(c, "w3", 1, "d4"),
+		testVote(c, "w1", 1, "d4"), // Only the first vote of a player counts.
+		testVote(c, "b1", 1, "e4"), // Not on the team to move.
+		testVote(c, "w4", 1, "e4"), // Not in the game.
+		testVote(c, "w3", 2, "e4"), // Wrong round.
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := state.GetChessState()
+	if cs.GetRoundIndex() != 2 {
+		t.Errorf("got round index %d; want 2", cs.GetRoundIndex())
+	}
+	if want := "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq d3 0 1"; cs.GetBoardFen() != want {
+		t.Errorf("got board %q; want %q", cs.GetBoardFen(), want)
+	}
+	if len(cs.GetMoveToCount()) != 0 {
+		t.Errorf("got move to count %v for new round; want empty", cs.GetMoveToCount())
+	}
+
+	h := c.history.GetStateHistory()
+	if len(h) != 1 {
+		t.Fatalf("got %d history entries; want 1", len(h))
+	}
+	if h[0].GetResult().GetMove() != "d4" {
+		t.Errorf("got applied move %q; want d4", h[0].GetResult().GetMove())
+	}
+	if h[0].GetMoveToCount()["d4"] != 2 || h[0].GetMoveToCount()["e4"] != 1 {
+		t.Errorf("got closed round tally %v; want d4: 2, e4: 1", h[0].GetMoveToCount())
+	}
+}
+
+func TestCloseRoundWithoutVotes(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	state, err := c.CloseRound(context.TODO(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	if state.GetChessState().GetRoundIndex() != 1 {
+		t.Errorf("got round index %d; want 1", state.GetChessState().GetRoundIndex())
+	}
+	if end := c.RoundEndTime(); end.Before(before.Add(30*time.Second)) || end.After(after.Add(30*time.Second)) {
+		t.Errorf("got round end time %v; want 30 seconds after the round was closed", end)
+	}
+	if len(c.history.GetStateHistory()) != 0 {
+		t.Errorf("got %d history entries; want 0", len(c.history.GetStateHistory()))
+	}
+}
+
+func TestMostVotedMove(t *testing.T) {
+	for _, tc := range []struct {
+		desc        string
+		moveToCount map[string]int64
+		want        string
+	}{
+		{"single", map[string]int64{"e4": 1}, "e4"},
+		{"most", map[string]int64{"e4": 1, "d4": 3, "Nf3": 2}, "d4"},
+		{"tie", map[string]int64{"e4": 2, "d4": 2}, "d4"},
+	} {
+		if got := mostVotedMove(tc.moveToCount); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestCloseRoundProbabilityIsReproducible(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_PROBABILITY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false})
+	committed := c.selectionSeedHash
+	if len(committed) == 0 {
+		t.Fatal("selection seed hash not published for first round")
+	}
+
+	state, err := c.CloseRound(context.TODO(), []*messages.Vote{
+		testVote(c, "w1", 1, "e4"),
+		testVote(c, "w2", 1, "d4"),
+		testVote(c, "w3", 1, "Nf3"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(state.GetChessState().GetSelectionSeedHash(), committed) {
+		t.Error("selection seed hash was not changed for the next round")
+	}
+
+	closed := c.history.GetStateHistory()[0]
+	seed := closed.GetResult().GetSelectionSeed()
+	if hash := sha256.Sum256(seed); !bytes.Equal(hash[:], committed) || !bytes.Equal(closed.GetSelectionSeedHash(), committed) {
+		t.Errorf("revealed seed does not match the published hash %x", committed)
+	}
+	if got := probabilityMove(closed.GetMoveToCount(), seed, closed.GetRoundIndex()); got != closed.GetResult().GetMove() {
+		t.Errorf("recomputed move %q; want applied move %q", got, closed.GetResult().GetMove())
+	}
+}
+
+func TestRestoreSecret(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_PROBABILITY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := c.Secret()
+	if len(secret) == 0 {
+		t.Fatal("got empty secret; want the selection seed")
+	}
+	hash := c.selectionSeedHash
+
+	c.selectionSeed = nil
+	if err := c.RestoreSecret([]byte("wrong seed")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("restoring wrong seed got error %v; want InvalidArgument", err)
+	}
+	if err := c.RestoreSecret(secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.selectionSeed, secret) {
+		t.Error("selection seed not restored")
+	}
+
+	if err := c.RestoreSecret(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.selectionSeedHash, hash) {
+		t.Error("published hash changed after the seed was lost; want it kept")
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true})
+	state, err := c.CloseRound(context.TODO(), []*messages.Vote{testVote(c, "w1", 1, "e4"), testVote(c, "w2", 1, "e4"), testVote(c, "w3", 1, "d4")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := c.history.GetStateHistory()[0]
+	if r := closed.GetResult(); r.GetMove() != "e4" || !r.GetSelectionSeedLost() || len(r.GetSelectionSeed()) != 0 {
+		t.Errorf("got result %v for a round with a lost seed; want the most voted move e4 flagged as seed lost", r)
+	}
+	if !bytes.Equal(closed.GetSelectionSeedHash(), hash) || bytes.Equal(state.GetChessState().GetSelectionSeedHash(), hash) {
+		t.Error("want the closed round to keep its published hash and the next round to publish a new one")
+	}
+}
+
+func TestProbabilityMove(t *testing.T) {
+	moveToCount := map[string]int64{"e4": 1, "d4": 3, "Nf3": 0}
+	picks := map[string]int{}
+	for s := 0; s < 400; s++ {
+		seed := []byte{byte(s), byte(s >> 8)}
+		m := probabilityMove(moveToCount, seed, 1)
+		if m != probabilityMove(moveToCount, seed, 1) {
+			t.Fatalf("seed %x selected different moves", seed)
+		}
+		picks[m]++
+	}
+	if picks["Nf3"] != 0 {
+		t.Errorf("move without votes was selected %d times", picks["Nf3"])
+	}
+	if picks["d4"] <= picks["e4"] {
+		t.Errorf("got picks %v; want d4 selected more often than e4", picks)
+	}
+}
+
+func TestAllVoted(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	if c.AllVoted() {
+		t.Error("AllVoted() with no players = true; want false")
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "b1": false})
+
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if c.AllVoted() {
+		t.Error("AllVoted() with w2 not voted = true; want false")
+	}
+	// w2 voted on a slave and an old round report for the joining player is ignored.
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{
+		testVote(c, "w2", 1, "d4"),
+		testVote(c, "w3", 0, "d4"),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.AllVoted() {
+		t.Error("AllVoted() with all white voted = false; want true")
+	}
+
+	// A player joining the team to move mid-round must vote before the round can close early.
+	addTestPlayers(t, c, map[string]bool{"w3": true})
+	if c.AllVoted() {
+		t.Error("AllVoted() after w3 joined = true; want false")
+	}
+	// Players joining the other team do not matter.
+	addTestPlayers(t, c, map[string]bool{"b2": false})
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{testVote(c, "w3", 1, "e4")}}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.AllVoted() {
+		t.Error("AllVoted() after w3 voted = false; want true")
+	}
+
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if c.AllVoted() {
+		t.Error("AllVoted() in new round = true; want false")
+	}
+}
+
+func TestDropSlaveVoters(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true})
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{testVote(c, "w1", 1, "e4")}, SlaveId: "slave-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{testVote(c, "w2", 1, "d4")}, SlaveId: "slave-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.AllVoted() {
+		t.Fatal("AllVoted() with all white reported = false; want true")
+	}
+
+	// The evicted slave's voters must vote again.
+	c.DropSlaveVoters("slave-1")
+	if c.AllVoted() {
+		t.Error("AllVoted() after dropping slave-1 voters = true; want false")
+	}
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{testVote(c, "w1", 1, "e4")}, SlaveId: "slave-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.AllVoted() {
+		t.Error("AllVoted() after w1 voted again = false; want true")
+	}
+}
+
+func TestSwitchPlayerTeam(t *testing.T) {
+	c, _, err := initializedGame(func(g *messages.Game) {
+		g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedAfterTally_{
+			VoteAppliedAfterTally: &messages.Game_Metadata_Rules_VoteAppliedAfterTally{TimeoutSeconds: 30},
+		}
+		g.GetMetadata().GetRules().GetChessRules().TeamSwitchCooldownSeconds = 3600
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "b1": false})
+	switchTeam := func(playerID string, white bool) error {
+		_, err := c.SwitchPlayerTeam(ctx, &pb.SwitchPlayerTeamRequest{
+			PlayerId: playerID,
+			Request: &pb.SwitchTeamRequest{
+				Fields: &messages.Game_NewPlayerFields{
+					Game: &messages.Game_NewPlayerFields_ChessFields{
+						ChessFields: &games.ChessNewPlayerFields{WhiteTeam: white},
+					},
+				},
+			},
+		})
+		return err
+	}
+
+	if _, err := c.AddPlayers(ctx, testJoinRequest("w1", false)); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("joining again with the other team got error %v; want FailedPrecondition", err)
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReportVoters(ctx, &pb.ReportVotersRequest{Votes: []*messages.Vote{testVote(c, "w2", 1, "d4")}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"w1", "w2"} {
+		if err := switchTeam(id, false); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("switching %s after voting got error %v; want FailedPrecondition", id, err)
+		}
+	}
+	if err := switchTeam("b1", false); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("switching to the same team got error %v; want AlreadyExists", err)
+	}
+	if err := switchTeam("b1", true); err != nil {
+		t.Fatal(err)
+	}
+	if c.playerToTeam["b1"] != true || c.teamToCount[true] != 3 || c.teamToCount[false] != 0 {
+		t.Errorf("got teams %v with counts %v after b1 switched; want b1 on white", c.playerToTeam, c.teamToCount)
+	}
+	if err := switchTeam("b1", false); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("switching back within the cooldown got error %v; want FailedPrecondition", err)
+	}
+
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchTeam("w1", false); err != nil {
+		t.Errorf("switching after the round closed got error %v", err)
+	}
 
+	c.metadata.GetRules().GetChessRules().TeamSwitching = false
+	if err := switchTeam("w2", false); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("switching when disabled got error %v; want FailedPrecondition", err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
 	ctx := context.TODO()
+	for _, tc := range []struct {
+		policy games.ChessRules_RebalancePolicy
+		// Players moved once b1 and b2 left.
+		wantMoved []string
+		// Error of b3 joining the unbalanced teams.
+		wantJoinSmaller codes.Code
+	}{
+		{games.ChessRules_NO_REBALANCE, nil, codes.FailedPrecondition},
+		{games.ChessRules_BLOCK_LARGER_TEAM_JOINS, nil, codes.OK},
+		{games.ChessRules_MOVE_RECENT_JOINERS, []string{"w2"}, codes.OK},
+	} {
+		c, _, err := initializedGame(func(g *messages.Game) {
+			g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedAfterTally_{
+				VoteAppliedAfterTally: &messages.Game_Metadata_Rules_VoteAppliedAfterTally{TimeoutSeconds: 30},
+			}
+			rules := g.GetMetadata().GetRules().GetChessRules()
+			rules.BalanceEnforcement = &games.ChessRules_TolerateDifference{TolerateDifference: 1}
+			rules.RebalancePolicy = tc.policy
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"w1", "b1", "w2", "b2", "w3"} {
+			addTestPlayers(t, c, map[string]bool{id: id[0] == 'w'})
+		}
+		// w3 joined last but voted this round so is not moved.
+		if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w3", 1, "e4")}); err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.RemovePlayers(ctx, &pb.RemovePlayersRequest{PlayerIds: []string{"b1", "b2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := res.GetState().GetChessState()
+		if fmt.Sprint(state.GetRebalancedPlayerIds()) != fmt.Sprint(tc.wantMoved) {
+			t.Errorf("%v: got players %v rebalanced; want %v", tc.policy, state.GetRebalancedPlayerIds(), tc.wantMoved)
+		}
+		for _, id := range tc.wantMoved {
+			if state.GetDetails().GetPlayerIdToTeam()[id] {
+				t.Errorf("%v: rebalanced player %s is still on white", tc.policy, id)
+			}
+		}
+
+		_, err = c.AddPlayers(ctx, testJoinRequest("w4", true))
+		if tc.wantMoved == nil && status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%v: joining the larger team got error %v; want FailedPrecondition", tc.policy, err)
+		}
+		_, err = c.AddPlayers(ctx, testJoinRequest("b3", false))
+		if tc.wantMoved == nil && status.Code(err) != tc.wantJoinSmaller {
+			t.Errorf("%v: joining the smaller team got error %v; want %v", tc.policy, err, tc.wantJoinSmaller)
+		}
+	}
+}
 
-	o, err := c.Initialize(ctx, &pb.InitializeRequest{
-		Game: &messages.Game{
-			Id:        "testID",
-			Location:  "testLocation",
-			StartTime: tNow.UnixNano(),
-			History:   nil,
-			Metadata: &messages.Game_Metadata{
-				Description: "testDescription",
-				Title:       "testTitle",
-				Visibility:  messages.Game_Metadata_OPEN,
-				Rules: &messages.Game_Metadata_Rules{
-					VoteApplication: &messages.Game_Metadata_Rules_VoteAppliedImmediately_{},
-					GameSpecific: &messages.Game_Metadata_Rules_ChessRules{
-						ChessRules: &games.ChessRules{
-							TeamSwitching: true,
-							BalancedTeams: true,
-							BalanceEnforcement: &games.ChessRules_TolerateDifference{
-								TolerateDifference: 10,
-							},
+func TestAddPlayersAnyTeam(t *testing.T) {
+	c, _, err := initializedDefaultGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true})
+	anyTeam := func(playerIDs ...string) *pb.AddPlayersRequest {
+		req := &pb.AddPlayersRequest{}
+		for _, id := range playerIDs {
+			req.Players = append(req.Players, &pb.AddPlayersRequest_NewPlayer{
+				PlayerId: id,
+				Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+					Fields: &messages.Game_NewPlayerFields{
+						Game: &messages.Game_NewPlayerFields_ChessFields{
+							ChessFields: &games.ChessNewPlayerFields{AnyTeam: true},
 						},
 					},
 				},
+			})
+		}
+		return req
+	}
+
+	// a joins the smaller team, b breaks the tie to white and c joins the then smaller black team.
+	res, err := c.AddPlayers(ctx, anyTeam("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, white := range map[string]bool{"a": false, "b": true, "c": false} {
+		if got := res.GetPlayerToFields()[id].GetChessFields().GetWhiteTeam(); got != white || c.playerToTeam[id] != white {
+			t.Errorf("player %s assigned white team %v; want %v", id, got, white)
+		}
+	}
+	// Joining again with no preference keeps the player's team.
+	res, err = c.AddPlayers(ctx, anyTeam("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetPlayerToFields()["a"].GetChessFields().GetWhiteTeam() || c.teamToCount[true] != 2 || c.teamToCount[false] != 2 {
+		t.Errorf("rejoining a got fields %v and counts %v; want a still on black", res.GetPlayerToFields()["a"], c.teamToCount)
+	}
+}
+
+func TestVotesFromTally(t *testing.T) {
+	slave, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, slave, map[string]bool{"w1": true, "w2": true, "w3": true})
+	addTestPlayers(t, master, map[string]bool{"w1": true, "w2": true})
+	for p, m := range map[string]string{"w1": "e4", "w2": "d4", "w3": "e4"} {
+		if _, err := slave.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(slave, p, 1, m)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := slave.GetVotes(ctx, &pb.GetVotesRequest{Aggregated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tally := res.GetTally()
+	if len(res.GetVotes()) != 0 || fmt.Sprint(tally.GetMoves(), tally.GetCounts()) != "[d4 e4] [1 2]" || len(tally.GetPlayerDigests()) != 3 {
+		t.Fatalf("got votes %v and tally %v; want only a tally of 3 players", res.GetVotes(), tally)
+	}
+
+	votes, err := master.VotesFromTally(res.GetRoundIndex(), tally)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, v := range votes {
+		got[v.GetPlayerId()] = v.GetChessVote().GetMove()
+	}
+	// w3 never joined the master so its vote is dropped.
+	if len(got) != 2 || got["w1"] != "e4" || got["w2"] != "d4" {
+		t.Errorf("got votes %v from the tally; want w1=e4 and w2=d4", got)
+	}
+
+	tally.Counts[0]++
+	if _, err := master.VotesFromTally(res.GetRoundIndex(), tally); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v for a tally whose counts do not match its players; want InvalidArgument", err)
+	}
+	tally.Counts[0]--
+	tally.PlayerMoves[0] = 5
+	if _, err := master.VotesFromTally(res.GetRoundIndex(), tally); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v for a tally with an unknown move; want InvalidArgument", err)
+	}
+}
+
+func TestGetVotesCursor(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false})
+	getVotes := func(cursor int64) (*pb.GetVotesResponse, string) {
+		t.Helper()
+		res, err := c.GetVotes(ctx, &pb.GetVotesRequest{Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		votes := []string{}
+		for _, v := range res.GetVotes() {
+			votes = append(votes, v.GetPlayerId()+"="+v.GetChessVote().GetMove())
+		}
+		sort.Strings(votes)
+		return res, fmt.Sprint(votes, res.GetRetractedPlayerIds())
+	}
+	for p, m := range map[string]string{"w1": "e4", "w2": "d4"} {
+		if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, p, 1, m)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, got := getVotes(0)
+	if !res.GetFull() || got != "[w1=e4 w2=d4] []" {
+		t.Fatalf("got full %t and votes %s without a cursor; want every vote", res.GetFull(), got)
+	}
+	cursor := res.GetCursor()
+	if res, got = getVotes(cursor); res.GetFull() || got != "[] []" || res.GetCursor() != cursor {
+		t.Errorf("got full %t, votes %s and cursor %d without changes; want none and cursor %d", res.GetFull(), got, res.GetCursor(), cursor)
+	}
+
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w3", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RemovePlayers(ctx, &pb.RemovePlayersRequest{PlayerIds: []string{"w2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if res, got = getVotes(cursor); res.GetFull() || got != "[w3=e4] [w2]" {
+		t.Errorf("got full %t and changes %s; want w3's vote and w2's retraction", res.GetFull(), got)
+	}
+	aggregated, err := c.GetVotes(ctx, &pb.GetVotesRequest{Aggregated: true, Cursor: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally := aggregated.GetTally(); aggregated.GetFull() || len(tally.GetPlayerDigests()) != 1 || tally.GetPlayerDigests()[0] != playerDigest("w3") ||
+		fmt.Sprint(tally.GetMoves(), tally.GetCounts(), aggregated.GetRetractedPlayerIds()) != "[e4] [1] [w2]" {
+		t.Errorf("got full %t, tally %v and retractions %v aggregated; want only w3's vote and w2's retraction",
+			aggregated.GetFull(), tally, aggregated.GetRetractedPlayerIds())
+	}
+	if counts := c.moveToCount; len(counts) != 1 || counts["e4"] != 2 {
+		t.Errorf("got tally %v after w2 left; want e4 twice", counts)
+	}
+	if res, got = getVotes(res.GetCursor() + 10); !res.GetFull() || got != "[w1=e4 w3=e4] []" {
+		t.Errorf("got full %t and votes %s with a cursor ahead of the server; want every vote", res.GetFull(), got)
+	}
+
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if res, got = getVotes(cursor); !res.GetFull() || got != "[] []" {
+		t.Errorf("got full %t and votes %s with a cursor of the closed round; want the new round's votes", res.GetFull(), got)
+	}
+}
+
+func TestRetractVote(t *testing.T) {
+	ctx := context.TODO()
+	newGame := func(changes messages.Game_Metadata_Rules_VoteChanges) *Implementation {
+		t.Helper()
+		c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.metadata.GetRules().VoteChanges = changes
+		c.metadata.GetRules().VoteLockSeconds = 60
+		addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true})
+		for p, m := range map[string]string{"w1": "e4", "w2": "e4"} {
+			if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, p, 1, m)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+
+	c := newGame(messages.Game_Metadata_Rules_ALLOW_VOTE_CHANGES)
+	res, err := c.RetractVote(ctx, &pb.RetractVoteRequest{PlayerId: "w1", RoundIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetVote().GetChessVote().GetMove() != "e4" {
+		t.Errorf("got retracted vote %v; want w1's vote for e4", res.GetVote())
+	}
+	if _, ok := c.playerToMove["w1"]; ok || c.moveToCount["e4"] != 1 {
+		t.Errorf("got votes %v and tally %v after w1 retracted; want only w2's vote", c.playerToMove, c.moveToCount)
+	}
+	if _, err := c.RetractVote(ctx, &pb.RetractVoteRequest{PlayerId: "w1", RoundIndex: 1}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v retracting twice; want NotFound", err)
+	}
+	if _, err := c.RetractVote(ctx, &pb.RetractVoteRequest{PlayerId: "w2", RoundIndex: 2}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v retracting in another round; want InvalidArgument", err)
+	}
+
+	c = newGame(messages.Game_Metadata_Rules_FINAL_VOTES)
+	if _, err := c.RetractVote(ctx, &pb.RetractVoteRequest{PlayerId: "w1", RoundIndex: 1}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v retracting a final vote; want FailedPrecondition", err)
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "d4")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v changing a final vote; want FailedPrecondition", err)
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Errorf("got error %v voting for the same move again; want it accepted", err)
+	}
+
+	c = newGame(messages.Game_Metadata_Rules_LOCKED_VOTES)
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "d4")}); err != nil {
+		t.Errorf("got error %v changing a vote before the lock; want it accepted", err)
+	}
+	c.endTime = time.Now().Add(30 * time.Second)
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v changing a locked vote; want FailedPrecondition", err)
+	}
+	if _, err := c.RetractVote(ctx, &pb.RetractVoteRequest{PlayerId: "w2", RoundIndex: 1}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v retracting a locked vote; want FailedPrecondition", err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w3": true})
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w3", 1, "e4")}); err != nil {
+		t.Errorf("got error %v casting a first vote while votes are locked; want it accepted", err)
+	}
+}
+
+func TestVoteNotations(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true, "w4": true})
+	for p, m := range map[string]string{"w1": "Nf3", "w2": "g1f3", "w3": "Ng1f3"} {
+		if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, p, 1, m)}); err != nil {
+			t.Fatalf("got error %v voting %s; want it accepted", err, m)
+		}
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w4", 1, "g1g3")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v voting an invalid move in UCI notation; want InvalidArgument", err)
+	}
+	res, err := c.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := res.GetState().GetChessState()
+	if counts := state.GetMoveToCount(); len(counts) != 1 || counts["Nf3"] != 3 {
+		t.Errorf("got tally %v; want Nf3 three times", counts)
+	}
+	if n := state.GetMoveNotations()["Nf3"]; n.GetAlgebraic() != "Nf3" || n.GetLongAlgebraic() != "Ng1f3" || n.GetUci() != "g1f3" {
+		t.Errorf("got notations %v for Nf3; want Nf3, Ng1f3 and g1f3", n)
+	}
+
+	// Changing the only e4 vote drops e4 from the tally rather than counting it zero times.
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w4", 1, "e2e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w4", 1, "g1f3")}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = c.State(ctx, &pb.StateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if counts := res.GetState().GetChessState().GetMoveToCount(); len(counts) != 1 || counts["Nf3"] != 4 {
+		t.Errorf("got tally %v after changing the e4 vote; want Nf3 four times", counts)
+	}
+
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e2e4"), testVote(c, "w2", 1, "e4"), testVote(c, "w3", 1, "Nf3")}); err != nil {
+		t.Fatal(err)
+	}
+	closed := c.history.GetStateHistory()[0]
+	if counts := closed.GetMoveToCount(); len(counts) != 2 || counts["e4"] != 2 || counts["Nf3"] != 1 {
+		t.Errorf("got closed round tally %v; want e4 twice and Nf3 once", counts)
+	}
+	if closed.GetResult().GetMove() != "e4" || closed.GetMoveNotations()["e4"].GetUci() != "e2e4" {
+		t.Errorf("got closed round move %s with notations %v; want e4 and e2e4", closed.GetResult().GetMove(), closed.GetMoveNotations())
+	}
+}
+
+func TestStalePositionVote(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true})
+	current := positionHash(c.game.FEN())
+	stale := positionHash("8/8/8/8/8/8/8/8 w - - 0 1")
+
+	v := testVote(c, "w1", 1, "e4")
+	v.GetChessVote().PositionHash = nil
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: v}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v voting without a position hash; want InvalidArgument", err)
+	}
+	v.GetChessVote().PositionHash = stale
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: v}); status.Code(err) != codes.Aborted {
+		t.Errorf("got error %v voting for a stale position; want Aborted", err)
+	}
+	v.GetChessVote().PositionHash = current
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: v}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.GetVotes(ctx, &pb.GetVotesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetVotes()) != 1 || !bytes.Equal(res.GetVotes()[0].GetChessVote().GetPositionHash(), current) {
+		t.Errorf("got votes %v; want w1's vote for the current position", res.GetVotes())
+	}
+
+	// Slave votes for a stale position or without a position hash are dropped when the master tallies the round.
+	staleVote := testVote(c, "w2", 1, "d4")
+	staleVote.GetChessVote().PositionHash = stale
+	unhashedVote := testVote(c, "w3", 1, "d4")
+	unhashedVote.GetChessVote().PositionHash = nil
+	if _, err := c.CloseRound(ctx, append(res.GetVotes(), staleVote, unhashedVote)); err != nil {
+		t.Fatal(err)
+	}
+	closed := c.history.GetStateHistory()[0]
+	if counts := closed.GetMoveToCount(); len(counts) != 1 || counts["e4"] != 1 {
+		t.Errorf("got closed round tally %v; want only w1's vote for e4", counts)
+	}
+}
+
+func TestApplyVote(t *testing.T) {
+	c, _, err := initializedGame(func(g *messages.Game) {
+		g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedImmediately_{
+			VoteAppliedImmediately: &messages.Game_Metadata_Rules_VoteAppliedImmediately{
+				CooldownSeconds: 60,
 			},
-			State: &messages.Game_State{
-				Game: &messages.Game_State_ChessState{
-					ChessState: &games.ChessState{
-						BoardFen:       "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
-						RoundIndex:     1,
-						RoundStartTime: tNow.UnixNano(),
-						RoundEndTime:   tNow.Add(time.Minute * 10).UnixNano(),
-						Details: &games.ChessState_Details{
-							PlayerIdToTeam: map[string]bool{},
-							PlayerToMove:   map[string]string{},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "b1": false})
+	ctx := context.TODO()
+
+	for _, tc := range []struct {
+		desc     string
+		vote     *messages.Vote
+		wantCode codes.Code
+	}{
+		{"wrong team", testVote(c, "b1", 1, "e5"), codes.PermissionDenied},
+		{"invalid move", testVote(c, "w1", 1, "e5"), codes.InvalidArgument},
+		{"first vote applied", testVote(c, "w1", 1, "e4"), codes.OK},
+		{"simultaneous vote rejected", testVote(c, "w2", 1, "d4"), codes.Aborted},
+		{"other team applied", testVote(c, "b1", 2, "e5"), codes.OK},
+		{"team cooling down", testVote(c, "w2", 3, "Nf3"), codes.FailedPrecondition},
+	} {
+		// The votes are cast for the position they are applied to.
+		tc.vote.GetChessVote().PositionHash = positionHash(c.game.FEN())
+		_, err := c.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: tc.vote})
+		if got := status.Code(err); got != tc.wantCode {
+			t.Errorf("%s: got code %v; want %v: %v", tc.desc, got, tc.wantCode, err)
+		}
+	}
+
+	h := c.history.GetStateHistory()
+	if len(h) != 2 {
+		t.Fatalf("got %d history entries; want 2", len(h))
+	}
+	if h[0].GetResult().GetMove() != "e4" || h[0].GetResult().GetPlayerId() != "w1" {
+		t.Errorf("got first result %v; want e4 by w1", h[0].GetResult())
+	}
+	if h[1].GetResult().GetMove() != "e5" || h[1].GetResult().GetPlayerId() != "b1" {
+		t.Errorf("got second result %v; want e5 by b1", h[1].GetResult())
+	}
+	if c.roundIndex != 3 {
+		t.Errorf("got round index %d; want 3", c.roundIndex)
+	}
+}
+
+func TestGameOver(t *testing.T) {
+	for _, tc := range []struct {
+		desc        string
+		moves       []string
+		wantOutcome games.ChessGameResult_Outcome
+		wantMethod  games.ChessGameResult_Method
+	}{
+		{
+			"checkmate",
+			[]string{"f3", "e5", "g4", "Qh4"},
+			games.ChessGameResult_BLACK_WON,
+			games.ChessGameResult_CHECKMATE,
+		},
+		{
+			"threefold repetition",
+			[]string{"Nf3", "Nf6", "Ng1", "Ng8", "Nf3", "Nf6", "Ng1", "Ng8"},
+			games.ChessGameResult_DRAW,
+			games.ChessGameResult_THREEFOLD_REPETITION,
+		},
+	} {
+		c, _, err := initializedGame(func(g *messages.Game) {
+			g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedImmediately_{
+				VoteAppliedImmediately: &messages.Game_Metadata_Rules_VoteAppliedImmediately{},
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addTestPlayers(t, c, map[string]bool{"w1": true, "b1": false})
+		ctx := context.TODO()
+
+		for n, m := range tc.moves {
+			player := "w1"
+			if n%2 == 1 {
+				player = "b1"
+			}
+			if _, err := c.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: testVote(c, player, int32(n+1), m)}); err != nil {
+				t.Fatalf("%s: applying move %s: %v", tc.desc, m, err)
+			}
+		}
+
+		if !c.Finished() {
+			t.Fatalf("%s: Finished() = false; want true", tc.desc)
+		}
+		res, err := c.State(ctx, &pb.StateRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := res.GetState().GetChessState().GetGameResult()
+		if got.GetOutcome() != tc.wantOutcome || got.GetMethod() != tc.wantMethod {
+			t.Errorf("%s: got result %v %v; want %v %v", tc.desc, got.GetOutcome(), got.GetMethod(), tc.wantOutcome, tc.wantMethod)
+		}
+		if got.GetFinalRound() != int32(len(tc.moves)) || got.GetFinalFen() != c.game.FEN() {
+			t.Errorf("%s: got final round %d with board %q; want %d with %q", tc.desc, got.GetFinalRound(), got.GetFinalFen(), len(tc.moves), c.game.FEN())
+		}
+		if c.history.GetGameResult() != got {
+			t.Errorf("%s: history result %v; want %v", tc.desc, c.history.GetGameResult(), got)
+		}
+
+		player := "w1"
+		if len(tc.moves)%2 == 1 {
+			player = "b1"
+		}
+		if _, err := c.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: testVote(c, player, int32(len(tc.moves)+1), "a3")}); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: ApplyVote() after game end got %v; want FailedPrecondition", tc.desc, err)
+		}
+		if _, err := c.AddPlayers(ctx, &pb.AddPlayersRequest{}); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: AddPlayers() after game end got %v; want FailedPrecondition", tc.desc, err)
+		}
+	}
+}
+
+func TestRestoreKeepsRepetitions(t *testing.T) {
+	immediate := func(g *messages.Game) {
+		g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedImmediately_{
+			VoteAppliedImmediately: &messages.Game_Metadata_Rules_VoteAppliedImmediately{},
+		}
+	}
+	c, _, err := initializedGame(immediate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "b1": false})
+	ctx := context.TODO()
+	moves := []string{"Nf3", "Nf6", "Ng1", "Ng8", "Nf3", "Nf6", "Ng1"}
+	for n, m := range moves {
+		player := "w1"
+		if n%2 == 1 {
+			player = "b1"
+		}
+		if _, err := c.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: testVote(c, player, int32(n+1), m)}); err != nil {
+			t.Fatalf("applying move %s: %v", m, err)
+		}
+	}
+	stateRes, err := c.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	historyRes, err := c.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored game only gets the current board in its state, the earlier positions come from the history.
+	restored, _, err := initializedGame(func(g *messages.Game) {
+		immediate(g)
+		g.State = stateRes.GetState()
+		g.History = historyRes.GetHistory()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: testVote(restored, "b1", int32(len(moves)+1), "Ng8")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.result; got.GetOutcome() != games.ChessGameResult_DRAW || got.GetMethod() != games.ChessGameResult_THREEFOLD_REPETITION {
+		t.Errorf("got result %v after repeating the starting position a third time; want a draw by threefold repetition", got)
+	}
+}
+
+func TestPostVoteOnFinishedGame(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true})
+	ctx := context.TODO()
+
+	res, err := c.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := res.GetState()
+	state.Version++
+	state.GetChessState().GameResult = &games.ChessGameResult{
+		Outcome: games.ChessGameResult_DRAW,
+		Method:  games.ChessGameResult_STALEMATE,
+	}
+	if _, err := c.UpdateState(ctx, &pb.UpdateStateRequest{State: state}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PostVote() on finished game got %v; want FailedPrecondition", err)
+	}
+}
+
+func TestStopGame(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true})
+	ctx := context.TODO()
+
+	if _, err := c.StopGame(ctx, &pb.StopGameRequest{Reason: "maintenance"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Finished() {
+		t.Error("Finished() after StopGame() got false; want true")
+	}
+	res, err := c.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := res.GetState().GetChessState().GetGameResult()
+	if result.GetMethod() != games.ChessGameResult_ABORTED || result.GetOutcome() != games.ChessGameResult_NO_OUTCOME {
+		t.Errorf("game result got %v/%v; want NO_OUTCOME/ABORTED", result.GetOutcome(), result.GetMethod())
+	}
+	if result.GetReason() != "maintenance" {
+		t.Errorf("game result reason got %q; want %q", result.GetReason(), "maintenance")
+	}
+
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PostVote() on stopped game got %v; want FailedPrecondition", err)
+	}
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e4")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CloseRound() on stopped game got %v; want FailedPrecondition", err)
+	}
+	if _, err := c.StopGame(ctx, &pb.StopGameRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("second StopGame() got %v; want FailedPrecondition", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.TODO()
+	res, err := (&Implementation{}).Status(ctx, &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetPhase() != pb.StatusResponse_UNINITIALIZED {
+		t.Errorf("uninitialized Status() phase got %v; want UNINITIALIZED", res.GetPhase())
+	}
+
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "b1": false})
+	stateRes, err := c.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := stateRes.GetState().GetChessState()
+	if res, err = c.Status(ctx, &pb.StatusRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if res.GetPhase() != pb.StatusResponse_RUNNING {
+		t.Errorf("Status() phase got %v; want RUNNING", res.GetPhase())
+	}
+	if res.GetRoundIndex() != state.GetRoundIndex() {
+		t.Errorf("Status() round index got %d; want %d", res.GetRoundIndex(), state.GetRoundIndex())
+	}
+	if res.GetRoundEndTime() != state.GetRoundEndTime() {
+		t.Errorf("Status() round end time got %d; want %d", res.GetRoundEndTime(), state.GetRoundEndTime())
+	}
+	if want := int64(time.Until(time.Unix(0, res.GetRoundEndTime())) / time.Second); res.GetSecondsRemaining() > want+1 || res.GetSecondsRemaining() < want-1 {
+		t.Errorf("Status() seconds remaining got %d; want about %d", res.GetSecondsRemaining(), want)
+	}
+	if res.GetTeamToCount()["white"] != 2 || res.GetTeamToCount()["black"] != 1 {
+		t.Errorf("Status() team counts got %v; want white: 2, black: 1", res.GetTeamToCount())
+	}
+
+	if _, err := c.StopGame(ctx, &pb.StopGameRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = c.Status(ctx, &pb.StatusRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if res.GetPhase() != pb.StatusResponse_FINISHED {
+		t.Errorf("Status() after StopGame() phase got %v; want FINISHED", res.GetPhase())
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	c, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	id, events := c.watchers.subscribe()
+	defer c.watchers.unsubscribe(id)
+
+	addTestPlayers(t, c, map[string]bool{"w1": true})
+	if _, err := c.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CloseRound(ctx, []*messages.Vote{testVote(c, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StopGame(ctx, &pb.StopGameRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pb.WatchGameResponse_Event{
+		pb.WatchGameResponse_PLAYERS_CHANGED,
+		pb.WatchGameResponse_TALLY_UPDATED,
+		pb.WatchGameResponse_ROUND_CLOSED,
+		pb.WatchGameResponse_ROUND_OPENED,
+		pb.WatchGameResponse_GAME_ENDED,
+	}
+	for _, w := range want {
+		e := <-events
+		if e.GetEvent() != w {
+			t.Fatalf("event got %v; want %v", e.GetEvent(), w)
+		}
+		switch w {
+		case pb.WatchGameResponse_TALLY_UPDATED:
+			if got := e.GetState().GetChessState().GetMoveToCount()["e4"]; got != 1 {
+				t.Errorf("TALLY_UPDATED e4 count got %d; want 1", got)
+			}
+		case pb.WatchGameResponse_ROUND_CLOSED:
+			if got := e.GetClosedRound().GetChessState().GetResult().GetMove(); got != "e4" {
+				t.Errorf("ROUND_CLOSED move got %q; want e4", got)
+			}
+		case pb.WatchGameResponse_ROUND_OPENED:
+			if got := e.GetState().GetChessState().GetRoundIndex(); got != 2 {
+				t.Errorf("ROUND_OPENED round got %d; want 2", got)
+			}
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e.GetEvent())
+	default:
+	}
+}
+
+func TestWatchersDropSlowSubscriber(t *testing.T) {
+	w := &watchers{}
+	slowID, slow := w.subscribe()
+	fastID, fast := w.subscribe()
+	defer w.unsubscribe(fastID)
+
+	for n := 0; n <= watchBufferSize; n++ {
+		w.publish(&pb.WatchGameResponse{})
+		<-fast
+	}
+	for n := 0; n < watchBufferSize; n++ {
+		if _, ok := <-slow; !ok {
+			t.Fatalf("slow subscriber closed after %d events; want %d buffered", n, watchBufferSize)
+		}
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow subscriber still open after overflowing its buffer")
+	}
+	w.unsubscribe(slowID) // Already removed, must not panic.
+}
+
+func TestUpdateStateVersions(t *testing.T) {
+	master, _, err := initializedDefaultGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, _, err := initializedDefaultGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	states := []*messages.Game_State{}
+	for _, p := range []string{"w1", "b1", "w2"} {
+		addTestPlayers(t, master, map[string]bool{p: p[0] == 'w'})
+		res, err := master.State(ctx, &pb.StateRequest{Detailed: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, res.GetState())
+	}
+	for n, s := range states {
+		if s.GetVersion() != int64(n+1) {
+			t.Fatalf("state %d version got %d; want %d", n, s.GetVersion(), n+1)
+		}
+	}
+
+	if _, err := slave.UpdateState(ctx, &pb.UpdateStateRequest{State: states[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slave.UpdateState(ctx, &pb.UpdateStateRequest{State: states[0]}); status.Code(err) != codes.Aborted {
+		t.Errorf("UpdateState() with repeated version got %v; want Aborted", err)
+	}
+	if _, err := slave.UpdateState(ctx, &pb.UpdateStateRequest{State: states[2]}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("UpdateState() skipping a version got %v; want OutOfRange", err)
+	}
+	if _, err := slave.UpdateState(ctx, &pb.UpdateStateRequest{State: states[2], Resync: true}); err != nil {
+		t.Fatalf("UpdateState() resync got %v; want nil", err)
+	}
+	if _, err := slave.UpdateState(ctx, &pb.UpdateStateRequest{State: states[1]}); status.Code(err) != codes.Aborted {
+		t.Errorf("UpdateState() with older version after resync got %v; want Aborted", err)
+	}
+	res, err := slave.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetState().GetVersion() != 3 || res.GetState().GetChessState().GetWhiteTeamCount() != 2 {
+		t.Errorf("slave state got version %d with %d white players; want version 3 with 2", res.GetState().GetVersion(), res.GetState().GetChessState().GetWhiteTeamCount())
+	}
+}
+
+func TestReinitializeFromNewMaster(t *testing.T) {
+	slave, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, _, err := initializedTallyGame(messages.Game_Metadata_Rules_VoteAppliedAfterTally_MOST_VOTES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+	addTestPlayers(t, slave, map[string]bool{"w1": true, "b1": false, "b2": false})
+	addTestPlayers(t, master, map[string]bool{"w1": true})
+	if _, err := slave.PostVote(ctx, &pb.PostVoteRequest{Vote: testVote(slave, "w1", 1, "e4")}); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataRes, err := master.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateRes, err := master.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &messages.Game{
+		Type:     messages.Game_CHESS,
+		Metadata: metadataRes.GetMetadata(),
+		State:    stateRes.GetState(),
+	}
+
+	missing := slave.MissingPlayers(g)
+	if len(missing) != 2 || missing[0].GetPlayerId() != "b1" || missing[1].GetPlayerId() != "b2" {
+		t.Fatalf("MissingPlayers() got %v; want b1 and b2", missing)
+	}
+	if missing[0].GetRequest().GetFields().GetChessFields().GetWhiteTeam() {
+		t.Error("MissingPlayers() b1 white team got true; want false")
+	}
+
+	if _, err := slave.Initialize(ctx, &pb.InitializeRequest{Game: g}); err != nil {
+		t.Fatal(err)
+	}
+	votesRes, err := slave.GetVotes(ctx, &pb.GetVotesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votesRes.GetVotes()) != 1 || votesRes.GetVotes()[0].GetPlayerId() != "w1" {
+		t.Errorf("votes after re-initializing in the same round got %v; want w1's vote", votesRes.GetVotes())
+	}
+	if got := slave.MissingPlayers(g); len(got) != 0 {
+		t.Errorf("MissingPlayers() after re-initializing got %v; want none", got)
+	}
+}
+
+func addTestPlayers(t *testing.T, c *Implementation, playerToTeam map[string]bool) {
+	t.Helper()
+	req := &pb.AddPlayersRequest{}
+	for id, white := range playerToTeam {
+		req.Players = append(req.Players, &pb.AddPlayersRequest_NewPlayer{
+			PlayerId: id,
+			Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+				Fields: &messages.Game_NewPlayerFields{
+					Game: &messages.Game_NewPlayerFields_ChessFields{
+						ChessFields: &games.ChessNewPlayerFields{
+							WhiteTeam: white,
 						},
 					},
 				},
 			},
+		})
+	}
+	if _, err := c.AddPlayers(context.TODO(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testJoinRequest(playerID string, white bool) *pb.AddPlayersRequest {
+	return &pb.AddPlayersRequest{Players: []*pb.AddPlayersRequest_NewPlayer{{
+		PlayerId: playerID,
+		Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+			Fields: &messages.Game_NewPlayerFields{
+				Game: &messages.Game_NewPlayerFields_ChessFields{
+					ChessFields: &games.ChessNewPlayerFields{WhiteTeam: white},
+				},
+			},
+		},
+	}}}
+}
+
+// testVote returns the player's vote cast for the current position of c.
+func testVote(c *Implementation, playerID string, round int32, move string) *messages.Vote {
+	return &messages.Vote{
+		PlayerId: playerID,
+		GameVote: &messages.Vote_ChessVote{
+			ChessVote: &games.ChessVote{
+				RoundIndex:   round,
+				Move:         move,
+				PositionHash: positionHash(c.game.FEN()),
+			},
 		},
+	}
+}
+
+func initializedTallyGame(selection messages.Game_Metadata_Rules_VoteAppliedAfterTally_SelectionType) (*Implementation, *pb.InitializeResponse, error) {
+	return initializedGame(func(g *messages.Game) {
+		g.GetMetadata().GetRules().VoteApplication = &messages.Game_Metadata_Rules_VoteAppliedAfterTally_{
+			VoteAppliedAfterTally: &messages.Game_Metadata_Rules_VoteAppliedAfterTally{
+				TimeoutSeconds: 30,
+				SelectionType:  selection,
+			},
+		}
 	})
+}
+
+func initializedDefaultGame() (*Implementation, *pb.InitializeResponse, error) {
+	return initializedGame(func(*messages.Game) {})
+}
+
+// initializedGame initializes a default game after passing it to setup for modification.
+func initializedGame(setup func(g *messages.Game)) (*Implementation, *pb.InitializeResponse, error) {
+	c := &Implementation{}
+
+	ctx := context.TODO()
+
+	g := &messages.Game{
+		Id:        "testID",
+		Location:  "testLocation",
+		StartTime: tNow.UnixNano(),
+		History:   nil,
+		Metadata: &messages.Game_Metadata{
+			Description: "testDescription",
+			Title:       "testTitle",
+			Visibility:  messages.Game_Metadata_OPEN,
+			Rules: &messages.Game_Metadata_Rules{
+				VoteApplication: &messages.Game_Metadata_Rules_VoteAppliedImmediately_{},
+				GameSpecific: &messages.Game_Metadata_Rules_ChessRules{
+					ChessRules: &games.ChessRules{
+						TeamSwitching: true,
+						BalancedTeams: true,
+						BalanceEnforcement: &games.ChessRules_TolerateDifference{
+							TolerateDifference: 10,
+						},
+					},
+				},
+			},
+		},
+		State: &messages.Game_State{
+			Game: &messages.Game_State_ChessState{
+				ChessState: &games.ChessState{
+					BoardFen:       "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+					RoundIndex:     1,
+					RoundStartTime: tNow.UnixNano(),
+					RoundEndTime:   tNow.Add(time.Minute * 10).UnixNano(),
+					Details: &games.ChessState_Details{
+						PlayerIdToTeam: map[string]bool{},
+						PlayerToMove:   map[string]string{},
+					},
+				},
+			},
+		},
+	}
+	setup(g)
+	o, err := c.Initialize(ctx, &pb.InitializeRequest{Game: g})
 	return c, o, err
 }
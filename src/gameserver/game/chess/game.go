@@ -2,6 +2,7 @@ package chess
 
 import (
 	"context"
+	"time"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages"
 
@@ -19,38 +20,112 @@ func (i *Implementation) Metadata(ctx context.Context, in *pb.MetadataRequest) (
 
 // State gets this game's state.
 func (i *Implementation) State(ctx context.Context, in *pb.StateRequest) (*pb.StateResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+	state := i.state(in.GetDetailed())
+	// Detailed states keep this server's own votes so they match the details.
+	if !in.GetDetailed() {
+		state.GetChessState().MoveToCount, state.GetChessState().TallyTime = i.liveTally()
+		state.GetChessState().MoveNotations = moveNotations(i.game.Position(), state.GetChessState().GetMoveToCount())
+	}
+	return &pb.StateResponse{
+		State: state,
+	}, nil
+}
+
+// state returns a copy of this game's state, including its details if detailed.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) state(detailed bool) *messages.Game_State {
 	var details *games.ChessState_Details
-	if in.GetDetailed() {
+	if detailed {
 		details = &games.ChessState_Details{
-			PlayerIdToTeam: i.playerToTeam,
-			PlayerToMove:   i.playerToMove,
+			PlayerIdToTeam:   make(map[string]bool, len(i.playerToTeam)),
+			PlayerToMove:     make(map[string]string, len(i.playerToMove)),
+			PlayerToJoinTime: make(map[string]int64, len(i.playerToJoinTime)),
+		}
+		for p, t := range i.playerToTeam {
+			details.PlayerIdToTeam[p] = t
+		}
+		for p, t := range i.playerToJoinTime {
+			details.PlayerToJoinTime[p] = t
+		}
+		for p, m := range i.playerToMove {
+			details.PlayerToMove[p] = m
 		}
 	}
-	return &pb.StateResponse{
-		State: &messages.Game_State{
-			Game: &messages.Game_State_ChessState{
-				ChessState: &games.ChessState{
-					WhiteTeamCount: i.teamToCount[true],
-					BlackTeamCount: i.teamToCount[false],
-					BoardFen:       i.game.FEN(),
-					MoveToCount:    i.moveToCount,
-					RoundStartTime: i.startTime.UnixNano(),
-					RoundEndTime:   i.endTime.UnixNano(),
-					Details:        details,
-					RoundIndex:     i.roundIndex,
-				},
+	moveToCount := make(map[string]int64, len(i.moveToCount))
+	for m, c := range i.moveToCount {
+		moveToCount[m] = c
+	}
+	return &messages.Game_State{
+		Version: i.version,
+		Game: &messages.Game_State_ChessState{
+			ChessState: &games.ChessState{
+				WhiteTeamCount:      i.teamToCount[true],
+				BlackTeamCount:      i.teamToCount[false],
+				BoardFen:            i.game.FEN(),
+				MoveToCount:         moveToCount,
+				MoveNotations:       moveNotations(i.game.Position(), moveToCount),
+				RoundStartTime:      i.startTime.UnixNano(),
+				RoundEndTime:        i.endTime.UnixNano(),
+				Details:             details,
+				RoundIndex:          i.roundIndex,
+				SelectionSeedHash:   i.selectionSeedHash,
+				GameResult:          i.result,
+				RebalancedPlayerIds: append([]string(nil), i.rebalanced...),
 			},
 		},
-	}, nil
+	}
 }
 
 // History gets this game's history.
 func (i *Implementation) History(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
 	return &pb.HistoryResponse{
 		History: &messages.Game_History{
 			Game: &messages.Game_History_ChessHistory{
-				ChessHistory: i.history,
+				ChessHistory: &games.ChessHistory{
+					StateHistory: append([]*games.ChessState{}, i.history.GetStateHistory()...),
+					GameResult:   i.history.GetGameResult(),
+				},
 			},
 		},
 	}, nil
 }
+
+// Status returns the phase, round timing and team counts of this game.
+// Server fields such as the instance ID and role are filled in by the surrounding gameslave/gamemaster.
+func (i *Implementation) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	if !i.initialized {
+		return &pb.StatusResponse{Phase: pb.StatusResponse_UNINITIALIZED}, nil
+	}
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+
+	res := &pb.StatusResponse{
+		Phase:      pb.StatusResponse_RUNNING,
+		RoundIndex: i.roundIndex,
+		TeamToCount: map[string]int64{
+			"white": i.teamToCount[true],
+			"black": i.teamToCount[false],
+		},
+	}
+	if i.result != nil {
+		res.Phase = pb.StatusResponse_FINISHED
+		return res, nil
+	}
+	if !i.endTime.IsZero() {
+		res.RoundEndTime = i.endTime.UnixNano()
+		if remaining := time.Until(i.endTime); remaining > 0 {
+			res.SecondsRemaining = int64(remaining / time.Second)
+		}
+	}
+	return res, nil
+}
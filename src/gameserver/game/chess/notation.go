@@ -0,0 +1,70 @@
+package chess
+
+import (
+	"strings"
+
+	ch "github.com/notnil/chess"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+)
+
+// voteDecoders are the notations votes may be cast in, tried in order.
+var voteDecoders = []ch.Decoder{
+	ch.AlgebraicNotation{},
+	ch.LongAlgebraicNotation{},
+	ch.UCINotation{},
+}
+
+// decodeMove decodes a move in standard algebraic (e.g. Nf3), long algebraic (e.g. Ng1f3) or UCI (e.g. g1f3) notation.
+// Returns nil if the move is not valid in the position.
+func decodeMove(pos *ch.Position, s string) *ch.Move {
+	s = strings.TrimSpace(s)
+	for _, d := range voteDecoders {
+		decoded, err := d.Decode(pos, s)
+		if err != nil {
+			continue
+		}
+		// UCI decoding does not check the move is valid in the position.
+		for _, m := range pos.ValidMoves() {
+			if m.S1() == decoded.S1() && m.S2() == decoded.S2() && m.Promo() == decoded.Promo() {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalMove returns the key the move is tallied under, its standard algebraic notation.
+func canonicalMove(pos *ch.Position, m *ch.Move) string {
+	return ch.AlgebraicNotation{}.Encode(pos, m)
+}
+
+// canonicalVote returns the key a move in any accepted notation is tallied under, or false if it is not valid
+// in the position.
+func canonicalVote(pos *ch.Position, s string) (string, bool) {
+	m := decodeMove(pos, s)
+	if m == nil {
+		return "", false
+	}
+	return canonicalMove(pos, m), true
+}
+
+// moveNotations returns each move tallied in moveToCount in every notation for the position.
+func moveNotations(pos *ch.Position, moveToCount map[string]int64) map[string]*games.ChessMoveNotations {
+	out := make(map[string]*games.ChessMoveNotations, len(moveToCount))
+	if len(moveToCount) == 0 {
+		return out
+	}
+	for _, m := range pos.ValidMoves() {
+		key := canonicalMove(pos, m)
+		if _, ok := moveToCount[key]; !ok {
+			continue
+		}
+		out[key] = &games.ChessMoveNotations{
+			Algebraic:     key,
+			LongAlgebraic: ch.LongAlgebraicNotation{}.Encode(pos, m),
+			Uci:           ch.UCINotation{}.Encode(pos, m),
+		}
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package chess
+
+import (
+	"context"
+	"time"
+
+	ch "github.com/notnil/chess"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+var (
+	errGameEnded = status.Error(codes.FailedPrecondition, "game has ended")
+
+	outcomeToProto = map[ch.Outcome]games.ChessGameResult_Outcome{
+		ch.NoOutcome: games.ChessGameResult_NO_OUTCOME,
+		ch.WhiteWon:  games.ChessGameResult_WHITE_WON,
+		ch.BlackWon:  games.ChessGameResult_BLACK_WON,
+		ch.Draw:      games.ChessGameResult_DRAW,
+	}
+	methodToProto = map[ch.Method]games.ChessGameResult_Method{
+		ch.NoMethod:             games.ChessGameResult_NO_METHOD,
+		ch.Checkmate:            games.ChessGameResult_CHECKMATE,
+		ch.Stalemate:            games.ChessGameResult_STALEMATE,
+		ch.ThreefoldRepetition:  games.ChessGameResult_THREEFOLD_REPETITION,
+		ch.FivefoldRepetition:   games.ChessGameResult_FIVEFOLD_REPETITION,
+		ch.FiftyMoveRule:        games.ChessGameResult_FIFTY_MOVE_RULE,
+		ch.SeventyFiveMoveRule:  games.ChessGameResult_SEVENTY_FIVE_MOVE_RULE,
+		ch.InsufficientMaterial: games.ChessGameResult_INSUFFICIENT_MATERIAL,
+	}
+)
+
+// StopGame is called by an authorized user and records this game as aborted.
+func (i *Implementation) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+	i.result = &games.ChessGameResult{
+		Outcome:    games.ChessGameResult_NO_OUTCOME,
+		Method:     games.ChessGameResult_ABORTED,
+		FinalFen:   i.game.FEN(),
+		FinalRound: i.roundIndex,
+		EndTime:    time.Now().UnixNano(),
+		Reason:     in.GetReason(),
+	}
+	i.history.GameResult = i.result
+	i.acceptingVotes = false
+	i.version++
+	i.publish(pb.WatchGameResponse_GAME_ENDED, nil)
+	return &pb.StopGameResponse{}, nil
+}
+
+// Finished returns whether the game has ended.
+func (i *Implementation) Finished() bool {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	return i.result != nil
+}
+
+// checkGameOver records the game's result if the last move, applied in round, ended it.
+// Draws by threefold repetition and the fifty move rule are claimed as soon as they are eligible since
+// there is no single player to claim them.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) checkGameOver(round int32, now time.Time) {
+	if i.game.Outcome() == ch.NoOutcome {
+		for _, m := range i.game.EligibleDraws() {
+			if m == ch.ThreefoldRepetition || m == ch.FiftyMoveRule {
+				i.game.Draw(m)
+				break
+			}
+		}
+	}
+	if i.game.Outcome() == ch.NoOutcome {
+		return
+	}
+	i.result = &games.ChessGameResult{
+		Outcome:    outcomeToProto[i.game.Outcome()],
+		Method:     methodToProto[i.game.Method()],
+		FinalFen:   i.game.FEN(),
+		FinalRound: round,
+		EndTime:    now.UnixNano(),
+	}
+	i.history.GameResult = i.result
+	i.acceptingVotes = false
+}
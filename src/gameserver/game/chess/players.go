@@ -4,78 +4,294 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages/games"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/sambdavidson/community-chess/src/proto/messages"
 	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
 )
 
 // AddPlayers is called by a GameServerSlave to request 1+ player(s) be added to this game.
 func (i *Implementation) AddPlayers(ctx context.Context, in *pb.AddPlayersRequest) (*pb.AddPlayersResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
 	i.teamsMux.Lock()
 	defer i.teamsMux.Unlock()
 
-	// Calculate if these new players will break balance enforcement
-	deltas := [2]int64{0, 0}
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+
+	// Calculate if these new players will break balance enforcement.
+	// Players joining again stay on their team, switching is only done by SwitchPlayerTeam.
+	deltas := map[bool]int64{}
+	joining := map[string]bool{}
+	playerToFields := map[string]*messages.Game_NewPlayerFields{}
 	for _, newPlayer := range in.GetPlayers() {
-		isWhite, onTeam := i.playerToTeam[newPlayer.GetPlayerId()]
-		if onTeam {
-			i := 0
-			if !isWhite {
-				i = 1
+		fields := newPlayer.GetRequest().GetFields().GetChessFields()
+		white := fields.GetWhiteTeam()
+		if isWhite, onTeam := i.playerToTeam[newPlayer.GetPlayerId()]; onTeam {
+			if !fields.GetAnyTeam() && isWhite != white {
+				return nil, i.switchByJoinErr(newPlayer.GetPlayerId())
 			}
-			deltas[i]--
+			playerToFields[newPlayer.GetPlayerId()] = newPlayerFields(isWhite)
+			continue
+		}
+		if _, ok := joining[newPlayer.GetPlayerId()]; ok {
+			continue
 		}
-		i := 0
-		if !newPlayer.GetRequest().GetFields().GetChessFields().GetWhiteTeam() {
-			i = 1
+		if fields.GetAnyTeam() {
+			// The smaller team keeps the teams balanced best, ties go to white.
+			white = i.teamToCount[true]+deltas[true] <= i.teamToCount[false]+deltas[false]
 		}
-		deltas[i]++
+		deltas[white]++
+		joining[newPlayer.GetPlayerId()] = white
+		playerToFields[newPlayer.GetPlayerId()] = newPlayerFields(white)
 	}
-	newWhite := i.teamToCount[true] + deltas[0]
-	newBlack := i.teamToCount[false] + deltas[1]
-	if err := validateNewTeamSizes(newWhite, newBlack, i.metadata.GetRules().GetChessRules()); err != nil {
+	if err := i.validateJoinTeamSizes(deltas); err != nil {
 		return nil, err
 	}
 
 	// New sizes check out, lets apply them.
-	for _, newPlayer := range in.GetPlayers() {
-		if isWhite, onTeam := i.playerToTeam[newPlayer.GetPlayerId()]; onTeam {
-			i.teamToCount[isWhite]--
-		}
-		i.teamToCount[newPlayer.GetRequest().GetFields().GetChessFields().GetWhiteTeam()]++
-		i.playerToTeam[newPlayer.GetPlayerId()] = newPlayer.GetRequest().GetFields().GetChessFields().GetWhiteTeam()
-
+	now := time.Now().UnixNano()
+	for playerID, white := range joining {
+		i.teamToCount[white]++
+		i.playerToTeam[playerID] = white
+		i.playerToJoinTime[playerID] = now
 	}
+	if len(joining) > 0 {
+		i.digestToPlayer = nil
+	}
+	i.rebalanced = nil
+	i.version++
+	i.publish(pb.WatchGameResponse_PLAYERS_CHANGED, nil)
 
-	res, err := i.State(ctx, &pb.StateRequest{Detailed: true})
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
 	return &pb.AddPlayersResponse{
-		State: res.State,
-	}, err
+		State:          i.state(true),
+		PlayerToFields: playerToFields,
+	}, nil
+}
+
+// SwitchPlayerTeam is called by a GameServerSlave to move a player that joined this game to another team.
+// Switching must be enabled by the rules, the player must not have voted this round and the player's last switch
+// must be longer ago than the rules' cooldown.
+func (i *Implementation) SwitchPlayerTeam(ctx context.Context, in *pb.SwitchPlayerTeamRequest) (*pb.SwitchPlayerTeamResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+	rules := i.metadata.GetRules().GetChessRules()
+	if !rules.GetTeamSwitching() {
+		return nil, status.Errorf(codes.FailedPrecondition, "team switching is disabled for this game")
+	}
+	playerID := in.GetPlayerId()
+	isWhite, onTeam := i.playerToTeam[playerID]
+	if !onTeam {
+		return nil, status.Errorf(codes.PermissionDenied, "player %s has not joined this game", playerID)
+	}
+	white := in.GetRequest().GetFields().GetChessFields().GetWhiteTeam()
+	if isWhite == white {
+		return nil, status.Errorf(codes.AlreadyExists, "player %s is already on team %s", playerID, teamName(white))
+	}
+	if i.votedThisRound(playerID) {
+		return nil, status.Errorf(codes.FailedPrecondition, "player %s voted this round and cannot switch until round %d closes", playerID, i.roundIndex)
+	}
+	now := time.Now()
+	cooldown := time.Duration(rules.GetTeamSwitchCooldownSeconds()) * time.Second
+	if wait := i.playerToLastSwitch[playerID].Add(cooldown).Sub(now); wait > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "player %s is cooling down from switching teams for %v", playerID, wait)
+	}
+
+	newWhite, newBlack := i.teamToCount[true]+1, i.teamToCount[false]-1
+	if !white {
+		newWhite, newBlack = i.teamToCount[true]-1, i.teamToCount[false]+1
+	}
+	if err := validateNewTeamSizes(newWhite, newBlack, rules); err != nil {
+		return nil, err
+	}
+	i.teamToCount[true], i.teamToCount[false] = newWhite, newBlack
+	i.playerToTeam[playerID] = white
+	i.playerToLastSwitch[playerID] = now
+	i.rebalanced = nil
+	i.version++
+	i.publish(pb.WatchGameResponse_PLAYERS_CHANGED, nil)
+
+	return &pb.SwitchPlayerTeamResponse{
+		State: i.state(true),
+	}, nil
+}
+
+// switchByJoinErr returns the error of a player joining again with the other team.
+func (i *Implementation) switchByJoinErr(playerID string) error {
+	if i.metadata.GetRules().GetChessRules().GetTeamSwitching() {
+		return status.Errorf(codes.FailedPrecondition, "player %s already joined, switch teams with SwitchTeam", playerID)
+	}
+	return status.Errorf(codes.FailedPrecondition, "player %s already joined and team switching is disabled for this game", playerID)
+}
+
+// newPlayerFields returns the fields of a player on the team.
+func newPlayerFields(white bool) *messages.Game_NewPlayerFields {
+	return &messages.Game_NewPlayerFields{
+		Game: &messages.Game_NewPlayerFields_ChessFields{
+			ChessFields: &games.ChessNewPlayerFields{
+				WhiteTeam: white,
+			},
+		},
+	}
+}
+
+// teamName returns the name of the team.
+func teamName(white bool) string {
+	if white {
+		return "white"
+	}
+	return "black"
 }
 
 // RemovePlayers is called by a GameServerSlave to request 1+ player(s) be removed from this game.
+// If the departures put the teams outside the balance bounds they are rebalanced by the rules' rebalance policy.
 func (i *Implementation) RemovePlayers(ctx context.Context, in *pb.RemovePlayersRequest) (*pb.RemovePlayersResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
 	i.teamsMux.Lock()
 	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
 
 	for _, playerID := range in.GetPlayerIds() {
 		if t, ok := i.playerToTeam[playerID]; ok {
 			i.teamToCount[t]--
 			delete(i.playerToTeam, playerID)
+			delete(i.playerToJoinTime, playerID)
+			i.digestToPlayer = nil
 		} else {
 			// TODO: metrics and logging
 			log.Printf("Removing already removed player %s\n", playerID)
 		}
 	}
+	i.retractDepartedVotes()
+	i.rebalanced = nil
+	if i.metadata.GetRules().GetChessRules().GetRebalancePolicy() == games.ChessRules_MOVE_RECENT_JOINERS {
+		i.rebalance()
+	}
+	i.version++
+	i.publish(pb.WatchGameResponse_PLAYERS_CHANGED, nil)
 
-	res, err := i.State(ctx, &pb.StateRequest{Detailed: true})
 	return &pb.RemovePlayersResponse{
-		State: res.State,
-	}, err
+		State: i.state(true),
+	}, nil
+}
+
+// rebalance moves the most recently joined players of the larger team to the smaller team until the teams are
+// within the balance bounds. Players that voted this round are not moved so nobody votes for both teams.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) rebalance() {
+	rules := i.metadata.GetRules().GetChessRules()
+	for validateNewTeamSizes(i.teamToCount[true], i.teamToCount[false], rules) != nil {
+		larger := i.teamToCount[true] > i.teamToCount[false]
+		if i.teamToCount[larger]-i.teamToCount[!larger] < 2 {
+			// Moving another player would only unbalance the other team.
+			return
+		}
+		moved := ""
+		for p, t := range i.playerToTeam {
+			if t != larger || i.votedThisRound(p) {
+				continue
+			}
+			// The latest joiner is moved, ties are broken by the largest player ID.
+			if moved == "" || i.playerToJoinTime[p] > i.playerToJoinTime[moved] ||
+				(i.playerToJoinTime[p] == i.playerToJoinTime[moved] && p > moved) {
+				moved = p
+			}
+		}
+		if moved == "" {
+			return
+		}
+		i.playerToTeam[moved] = !larger
+		i.teamToCount[larger]--
+		i.teamToCount[!larger]++
+		i.rebalanced = append(i.rebalanced, moved)
+		log.Printf("rebalanced player %s to team %s", moved, teamName(!larger))
+	}
+}
+
+// votedThisRound returns whether the player voted this round on this server or on a slave that reported it.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) votedThisRound(playerID string) bool {
+	if _, ok := i.playerToMove[playerID]; ok {
+		return true
+	}
+	_, ok := i.roundVoters[playerID]
+	return ok
+}
+
+// validateJoinTeamSizes validates the team sizes after the new players join, deltas holding the players joining
+// each team. Unless the rebalance policy blocks joins to the larger team, the new sizes must be within the bounds.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) validateJoinTeamSizes(deltas map[bool]int64) error {
+	rules := i.metadata.GetRules().GetChessRules()
+	white, black := i.teamToCount[true], i.teamToCount[false]
+	newWhite, newBlack := white+deltas[true], black+deltas[false]
+	err := validateNewTeamSizes(newWhite, newBlack, rules)
+	if err == nil || rules.GetRebalancePolicy() != games.ChessRules_BLOCK_LARGER_TEAM_JOINS ||
+		validateNewTeamSizes(white, black, rules) == nil {
+		return err
+	}
+	// The teams were already unbalanced by departures, players may only join the smaller team.
+	larger := white > black
+	if deltas[larger] > 0 {
+		return status.Errorf(codes.FailedPrecondition, "teams are unbalanced (white: %d; black: %d), only team %s may be joined", white, black, teamName(!larger))
+	}
+	if abs(newWhite-newBlack) >= abs(white-black) {
+		return err
+	}
+	return nil
+}
+
+// abs returns the absolute value of n.
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// MissingPlayers returns requests re-adding every player known to this server but missing from the game, in player ID order.
+func (i *Implementation) MissingPlayers(game *messages.Game) []*pb.AddPlayersRequest_NewPlayer {
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+
+	known := game.GetState().GetChessState().GetDetails().GetPlayerIdToTeam()
+	ids := []string{}
+	for id := range i.playerToTeam {
+		if _, ok := known[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	out := make([]*pb.AddPlayersRequest_NewPlayer, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, &pb.AddPlayersRequest_NewPlayer{
+			PlayerId: id,
+			Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+				Fields: newPlayerFields(i.playerToTeam[id]),
+			},
+		})
+	}
+	return out
 }
 
 func validateNewTeamSizes(white, black int64, rules *games.ChessRules) error {
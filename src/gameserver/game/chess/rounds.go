@@ -0,0 +1,176 @@
+package chess
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ch "github.com/notnil/chess"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// RoundEndTime returns the time the current round is scheduled to close.
+func (i *Implementation) RoundEndTime() time.Time {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	return i.endTime
+}
+
+// AllVoted returns whether every player on the team to move has voted this round on any server.
+// Returns false if the team to move has no players.
+func (i *Implementation) AllVoted() bool {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	whiteTurn := i.game.Position().Turn() == ch.White
+	if i.teamToCount[whiteTurn] <= 0 {
+		return false
+	}
+	for p, t := range i.playerToTeam {
+		if t != whiteTurn {
+			continue
+		}
+		if _, ok := i.playerToMove[p]; ok {
+			continue
+		}
+		if _, ok := i.roundVoters[p]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// countRoundVotes returns how many of the votes gathered from every server chose each move, keyed by canonicalMove
+// whichever notation the votes used. Votes for other rounds or positions, without a position hash,
+// from players not on the team to move or with invalid moves are ignored, as are all but the first vote of each player.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) countRoundVotes(votes []*messages.Vote) map[string]int64 {
+	whiteTurn := i.game.Position().Turn() == ch.White
+	hash := positionHash(i.game.FEN())
+	voted := map[string]bool{}
+	moveToCount := map[string]int64{}
+	for _, v := range votes {
+		if v.GetChessVote().GetRoundIndex() != i.roundIndex {
+			continue
+		}
+		if t, ok := i.playerToTeam[v.GetPlayerId()]; !ok || t != whiteTurn {
+			continue
+		}
+		if voted[v.GetPlayerId()] {
+			continue
+		}
+		if !bytes.Equal(v.GetChessVote().GetPositionHash(), hash) {
+			continue
+		}
+		move, ok := canonicalVote(i.game.Position(), v.GetChessVote().GetMove())
+		if !ok {
+			continue
+		}
+		voted[v.GetPlayerId()] = true
+		moveToCount[move]++
+	}
+	return moveToCount
+}
+
+// CloseRound tallies the votes gathered from every server of this game for the current round, applies the
+// selected move and opens the next round. Votes for other rounds or positions, from players not on the team to move or
+// with invalid moves are ignored, as are all but the first vote of each player.
+// If no valid votes were cast the current round is reopened with a new end time.
+func (i *Implementation) CloseRound(ctx context.Context, votes []*messages.Vote) (*messages.Game_State, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if i.metadata.GetRules().GetVoteAppliedAfterTally() == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "game votes are not applied after a tally")
+	}
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+
+	moveToCount := i.countRoundVotes(votes)
+
+	now := time.Now()
+	timeout := time.Duration(i.metadata.GetRules().GetVoteAppliedAfterTally().GetTimeoutSeconds()) * time.Second
+	if len(moveToCount) == 0 {
+		i.endTime = now.Add(timeout)
+		i.version++
+		i.publish(pb.WatchGameResponse_ROUND_OPENED, nil)
+		return i.state(true), nil
+	}
+
+	probability := i.metadata.GetRules().GetVoteAppliedAfterTally().GetSelectionType() == messages.Game_Metadata_Rules_VoteAppliedAfterTally_PROBABILITY
+	// The next round's seed is generated first so a failure leaves this round untouched.
+	var nextSeed, nextSeedHash []byte
+	if probability {
+		var err error
+		if nextSeed, nextSeedHash, err = generateSelectionSeed(); err != nil {
+			return nil, err
+		}
+	}
+	selected := mostVotedMove(moveToCount)
+	var seed []byte
+	seedLost := false
+	if probability {
+		seed = i.selectionSeed
+		if len(seed) == 0 {
+			// A new seed can no longer be committed to once votes are known, so the round fails closed.
+			seedLost = true
+		} else {
+			selected = probabilityMove(moveToCount, seed, i.roundIndex)
+		}
+	}
+	m, err := (ch.AlgebraicNotation{}).Decode(i.game.Position(), selected)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed decoding selected move %s: %v", selected, err)
+	}
+	closed := &games.ChessState{
+		WhiteTeamCount: i.teamToCount[true],
+		BlackTeamCount: i.teamToCount[false],
+		BoardFen:       i.game.FEN(),
+		MoveToCount:    moveToCount,
+		MoveNotations:  moveNotations(i.game.Position(), moveToCount),
+		RoundStartTime: i.startTime.UnixNano(),
+		RoundEndTime:   now.UnixNano(),
+		RoundIndex:     i.roundIndex,
+		Result: &games.ChessRoundResult{
+			Move:              selected,
+			SelectionSeed:     seed,
+			SelectionSeedLost: seedLost,
+		},
+		SelectionSeedHash: i.selectionSeedHash,
+	}
+	if err := i.game.Move(m); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed applying selected move %s: %v", selected, err)
+	}
+	i.history.StateHistory = append(i.history.GetStateHistory(), closed)
+	i.checkGameOver(closed.GetRoundIndex(), now)
+
+	i.roundIndex++
+	i.startTime = now
+	i.endTime = now.Add(timeout)
+	i.playerToMove = map[string]string{}
+	i.moveToCount = map[string]int64{}
+	i.roundVoters = map[string]string{}
+	i.resetVoteSeqs()
+	i.version++
+	if probability {
+		i.selectionSeed, i.selectionSeedHash = nextSeed, nextSeedHash
+	}
+	i.publishRoundClosed(closed)
+
+	return i.state(true), nil
+}
@@ -0,0 +1,118 @@
+package chess
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"sort"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// selectionSeedSize is the number of random bytes in a selection seed.
+const selectionSeedSize = 32
+
+// newSelectionSeed generates a seed for the current round and publishes its hash.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) newSelectionSeed() error {
+	seed, hash, err := generateSelectionSeed()
+	if err != nil {
+		return err
+	}
+	i.selectionSeed, i.selectionSeedHash = seed, hash
+	return nil
+}
+
+// generateSelectionSeed returns a new random selection seed and its hash.
+func generateSelectionSeed() ([]byte, []byte, error) {
+	seed := make([]byte, selectionSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "failed generating selection seed: %v", err)
+	}
+	hash := sha256.Sum256(seed)
+	return seed, hash[:], nil
+}
+
+// Secret returns a copy of the current round's selection seed, or nil if moves are not selected by probability.
+func (i *Implementation) Secret() []byte {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	if len(i.selectionSeed) == 0 {
+		return nil
+	}
+	return append([]byte{}, i.selectionSeed...)
+}
+
+// RestoreSecret restores the current round's selection seed, which must match its published hash.
+// If the seed was lost the published hash is kept and the round falls back to the most voted move when it closes,
+// flagged in its result, rather than committing to a new seed once votes are known.
+func (i *Implementation) RestoreSecret(secret []byte) error {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	if i.metadata.GetRules().GetVoteAppliedAfterTally().GetSelectionType() != messages.Game_Metadata_Rules_VoteAppliedAfterTally_PROBABILITY || i.result != nil {
+		return nil
+	}
+	if len(secret) == 0 {
+		log.Printf("selection seed for round %d lost, the round will select the most voted move", i.roundIndex)
+		i.selectionSeed = nil
+		return nil
+	}
+	hash := sha256.Sum256(secret)
+	if !bytes.Equal(hash[:], i.selectionSeedHash) {
+		return status.Error(codes.InvalidArgument, "selection seed does not match its published hash")
+	}
+	i.selectionSeed = append([]byte{}, secret...)
+	return nil
+}
+
+// mostVotedMove returns the move with the most votes. Ties are broken by the lexicographically smallest move.
+func mostVotedMove(moveToCount map[string]int64) string {
+	selected := ""
+	for _, m := range sortedMoves(moveToCount) {
+		if selected == "" || moveToCount[m] > moveToCount[selected] {
+			selected = m
+		}
+	}
+	return selected
+}
+
+// probabilityMove returns a move selected at random, weighted by its number of votes. The selection is
+// deterministic for a given seed and round, see the ChessRoundResult proto for the algorithm.
+func probabilityMove(moveToCount map[string]int64, seed []byte, roundIndex int32) string {
+	var total uint64
+	for _, c := range moveToCount {
+		total += uint64(c)
+	}
+	if total == 0 {
+		return ""
+	}
+
+	round := make([]byte, 4)
+	binary.BigEndian.PutUint32(round, uint32(roundIndex))
+	sum := sha256.Sum256(append(append([]byte{}, seed...), round...))
+	pick := binary.BigEndian.Uint64(sum[:8]) % total
+
+	var running uint64
+	for _, m := range sortedMoves(moveToCount) {
+		running += uint64(moveToCount[m])
+		if running > pick {
+			return m
+		}
+	}
+	return ""
+}
+
+// sortedMoves returns the moves of moveToCount sorted by byte order.
+func sortedMoves(moveToCount map[string]int64) []string {
+	moves := make([]string, 0, len(moveToCount))
+	for m := range moveToCount {
+		moves = append(moves, m)
+	}
+	sort.Strings(moves)
+	return moves
+}
@@ -0,0 +1,184 @@
+package chess
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// playerDigest returns the digest of the player sent in a VoteTally instead of its ID.
+func playerDigest(playerID string) uint64 {
+	sum := sha256.Sum256([]byte(playerID))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// tally aggregates the votes of the current round added or changed after the GetVotes cursor changedAfter,
+// every vote if it is 0.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) tally(changedAfter int64) *pb.VoteTally {
+	playerToMove := make(map[string]string, len(i.playerToMove))
+	moveToCount := map[string]int64{}
+	for p, m := range i.playerToMove {
+		if i.playerToVoteSeq[p] <= changedAfter {
+			continue
+		}
+		playerToMove[p] = m
+		moveToCount[m]++
+	}
+	t := &pb.VoteTally{
+		Moves:         make([]string, 0, len(moveToCount)),
+		Counts:        make([]int64, 0, len(moveToCount)),
+		PlayerDigests: make([]uint64, 0, len(playerToMove)),
+		PlayerMoves:   make([]int32, 0, len(playerToMove)),
+		PositionHash:  positionHash(i.game.FEN()),
+	}
+	for m := range moveToCount {
+		t.Moves = append(t.Moves, m)
+	}
+	sort.Strings(t.Moves)
+	moveIndex := make(map[string]int32, len(t.Moves))
+	for idx, m := range t.Moves {
+		t.Counts = append(t.Counts, moveToCount[m])
+		moveIndex[m] = int32(idx)
+	}
+
+	type digestMove struct {
+		digest uint64
+		move   int32
+	}
+	dms := make([]digestMove, 0, len(playerToMove))
+	for p, m := range playerToMove {
+		dms = append(dms, digestMove{playerDigest(p), moveIndex[m]})
+	}
+	sort.Slice(dms, func(a, b int) bool {
+		return dms[a].digest < dms[b].digest
+	})
+	for _, dm := range dms {
+		t.PlayerDigests = append(t.PlayerDigests, dm.digest)
+		t.PlayerMoves = append(t.PlayerMoves, dm.move)
+	}
+	return t
+}
+
+// VotesFromTally returns the votes of a slave's tally of the round, matching its digests to the players of this game.
+// Digests of players that have not joined are dropped, as CloseRound would drop their votes.
+// If the tally is malformed returns a GRPC status error.
+func (i *Implementation) VotesFromTally(roundIndex int32, t *pb.VoteTally) ([]*messages.Vote, error) {
+	if len(t.GetMoves()) != len(t.GetCounts()) || len(t.GetPlayerDigests()) != len(t.GetPlayerMoves()) {
+		return nil, status.Errorf(codes.InvalidArgument, "tally has %d moves for %d counts and %d digests for %d player moves",
+			len(t.GetMoves()), len(t.GetCounts()), len(t.GetPlayerDigests()), len(t.GetPlayerMoves()))
+	}
+	counts := make([]int64, len(t.GetMoves()))
+	for _, m := range t.GetPlayerMoves() {
+		if m < 0 || int(m) >= len(counts) {
+			return nil, status.Errorf(codes.InvalidArgument, "tally player move %d out of range of %d moves", m, len(counts))
+		}
+		counts[m]++
+	}
+	for idx, c := range t.GetCounts() {
+		if counts[idx] != c {
+			return nil, status.Errorf(codes.InvalidArgument, "tally counts %d votes for %s but has %d players voting for it", c, t.GetMoves()[idx], counts[idx])
+		}
+	}
+
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	if i.digestToPlayer == nil {
+		i.digestToPlayer = make(map[uint64]string, len(i.playerToTeam))
+		for p := range i.playerToTeam {
+			i.digestToPlayer[playerDigest(p)] = p
+		}
+	}
+	votes := make([]*messages.Vote, 0, len(t.GetPlayerDigests()))
+	for idx, d := range t.GetPlayerDigests() {
+		p, ok := i.digestToPlayer[d]
+		if !ok {
+			continue
+		}
+		votes = append(votes, &messages.Vote{
+			PlayerId: p,
+			GameVote: &messages.Vote_ChessVote{
+				ChessVote: &games.ChessVote{
+					RoundIndex:   roundIndex,
+					Move:         t.GetMoves()[t.GetPlayerMoves()[idx]],
+					PositionHash: t.GetPositionHash(),
+				},
+			},
+		})
+	}
+	return votes, nil
+}
+
+// CountVotes returns how many of the votes gathered from every server of this game chose each move, counting only
+// the votes CloseRound would count.
+func (i *Implementation) CountVotes(votes []*messages.Vote) map[string]int64 {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	return i.countRoundVotes(votes)
+}
+
+// UpdateTally is called by the master with the current round's votes merged from every server, which are then
+// returned by State and WatchGame in place of this server's own votes. Tallies of other rounds are ignored.
+func (i *Implementation) UpdateTally(ctx context.Context, in *pb.UpdateTallyRequest) (*pb.UpdateTallyResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if in.GetRoundIndex() != i.roundIndex {
+		return &pb.UpdateTallyResponse{}, nil
+	}
+	changed := !i.merged() || !equalCounts(i.mergedMoveToCount, in.GetMoveToCount())
+	i.mergedRound = in.GetRoundIndex()
+	i.mergedMoveToCount = in.GetMoveToCount()
+	i.mergedTime = time.Unix(0, in.GetTallyTime())
+	if changed {
+		i.publish(pb.WatchGameResponse_TALLY_UPDATED, nil)
+	}
+	return &pb.UpdateTallyResponse{}, nil
+}
+
+// merged returns whether the master has merged the current round's votes from every server.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) merged() bool {
+	return i.mergedRound == i.roundIndex && !i.mergedTime.IsZero()
+}
+
+// liveTally returns a copy of the current round's tally merged from every server and when it was merged in Nanos
+// since EPOCH, or a copy of this server's own tally and 0 if the master has not merged the round's votes yet.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) liveTally() (map[string]int64, int64) {
+	moveToCount, tallyTime := i.moveToCount, int64(0)
+	if i.merged() {
+		moveToCount, tallyTime = i.mergedMoveToCount, i.mergedTime.UnixNano()
+	}
+	out := make(map[string]int64, len(moveToCount))
+	for m, c := range moveToCount {
+		out[m] = c
+	}
+	return out, tallyTime
+}
+
+// equalCounts returns whether both tallies count the same votes for the same moves.
+func equalCounts(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for m, c := range a {
+		if b[m] != c {
+			return false
+		}
+	}
+	return true
+}
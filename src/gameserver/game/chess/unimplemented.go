@@ -35,12 +35,42 @@ func (i *Implementation) AddSlave(ctx context.Context, in *pb.AddSlaveRequest) (
 	return nil, unimplementedErr
 }
 
-// Status returns the status of this game (and/or the underlying server). TODO: figure out what to do here.
-func (i *Implementation) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+// Resync is not implemented, handled by surrounding gamemaster
+func (i *Implementation) Resync(ctx context.Context, in *pb.ResyncRequest) (*pb.ResyncResponse, error) {
 	return nil, unimplementedErr
 }
 
-// StopGame is called by an authorized user and shuts down this game.
-func (i *Implementation) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
+// Heartbeat is not implemented, handled by surrounding gamemaster
+func (i *Implementation) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	return nil, unimplementedErr
+}
+
+// RemoveSlave is not implemented, handled by surrounding gamemaster
+func (i *Implementation) RemoveSlave(ctx context.Context, in *pb.RemoveSlaveRequest) (*pb.RemoveSlaveResponse, error) {
+	return nil, unimplementedErr
+}
+
+// CreateInvite is not implemented, handled by surrounding gameserver
+func (i *Implementation) CreateInvite(ctx context.Context, in *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	return nil, unimplementedErr
+}
+
+// RevokeInvite is not implemented, handled by surrounding gameserver
+func (i *Implementation) RevokeInvite(ctx context.Context, in *pb.RevokeInviteRequest) (*pb.RevokeInviteResponse, error) {
+	return nil, unimplementedErr
+}
+
+// UpdateAllowList is not implemented, handled by surrounding gameserver
+func (i *Implementation) UpdateAllowList(ctx context.Context, in *pb.UpdateAllowListRequest) (*pb.UpdateAllowListResponse, error) {
+	return nil, unimplementedErr
+}
+
+// UpdateAccess is not implemented, handled by surrounding gamemaster
+func (i *Implementation) UpdateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	return nil, unimplementedErr
+}
+
+// SwitchTeam is not implemented, ultimately handled by master's SwitchPlayerTeam()
+func (i *Implementation) SwitchTeam(ctx context.Context, in *pb.SwitchTeamRequest) (*pb.SwitchTeamResponse, error) {
 	return nil, unimplementedErr
 }
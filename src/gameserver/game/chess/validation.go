@@ -11,6 +11,9 @@ func validateChessRules(r *games.ChessRules) error {
 	if r == nil {
 		return status.Errorf(codes.InvalidArgument, "missing chess specific rules")
 	}
+	if r.GetTeamSwitchCooldownSeconds() < 0 {
+		return status.Errorf(codes.InvalidArgument, "team switch cooldown cannot be negative")
+	}
 	if r.GetTolerateDifference() != 0 {
 		if r.GetTolerateDifference() < 1 {
 			return status.Errorf(codes.InvalidArgument, "balance enforcement tolerate difference cannot be less than 1")
@@ -1,7 +1,11 @@
 package chess
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"sort"
+	"time"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages/games"
 
@@ -21,53 +25,336 @@ func (i *Implementation) ChangeAcceptingVotes(ctx context.Context, in *pb.Change
 	return &pb.ChangeAcceptingVotesResponse{}, nil
 }
 
-// GetVotes is called by GameServerMasters get all votes received by this GameServerSlave for the current round.
+// GetVotes is called by GameServerMasters get all votes received by this GameServerSlave for the current round,
+// either one vote per player or aggregated as a tally. With a cursor of the current round only the votes changed
+// since the cursor are returned.
 func (i *Implementation) GetVotes(ctx context.Context, in *pb.GetVotesRequest) (*pb.GetVotesResponse, error) {
 	i.moveMux.Lock()
 	defer i.moveMux.Unlock()
 
-	votes := []*messages.Vote{}
-	for p, m := range i.playerToMove {
-		votes = append(votes, &messages.Vote{
-			PlayerId: p,
+	res := &pb.GetVotesResponse{
+		Complete:   !i.acceptingVotes,
+		RoundIndex: i.roundIndex,
+		Cursor:     i.voteSeq,
+		Full:       in.GetCursor() == 0 || in.GetCursor() < i.roundVoteSeq || in.GetCursor() > i.voteSeq,
+	}
+	changedAfter := in.GetCursor()
+	if res.GetFull() {
+		changedAfter = 0
+	}
+	if in.GetAggregated() {
+		res.Tally = i.tally(changedAfter)
+	} else {
+		res.Votes = []*messages.Vote{}
+		hash := positionHash(i.game.FEN())
+		for p, m := range i.playerToMove {
+			if i.playerToVoteSeq[p] <= changedAfter {
+				continue
+			}
+			res.Votes = append(res.Votes, &messages.Vote{
+				PlayerId: p,
+				GameVote: &messages.Vote_ChessVote{
+					ChessVote: &games.ChessVote{
+						RoundIndex:   i.roundIndex,
+						Move:         m,
+						PositionHash: hash,
+					},
+				},
+			})
+		}
+	}
+	if !res.GetFull() {
+		for p := range i.retracted {
+			if i.playerToVoteSeq[p] > in.GetCursor() {
+				res.RetractedPlayerIds = append(res.RetractedPlayerIds, p)
+			}
+		}
+		sort.Strings(res.RetractedPlayerIds)
+	}
+	return res, nil
+}
+
+// PostVote posts a vote to this game.
+func (i *Implementation) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if i.metadata.GetRules().GetVoteAppliedImmediately() != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "votes are applied immediately by the master")
+	}
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+	if !i.acceptingVotes {
+		return nil, status.Errorf(codes.FailedPrecondition, "round %d is not accepting votes", i.roundIndex)
+	}
+	if in.GetVote().GetChessVote().GetRoundIndex() != i.roundIndex {
+		return nil, status.Errorf(codes.InvalidArgument, "bad round index %d; current round %d", in.GetVote().GetChessVote().GetRoundIndex(), i.roundIndex)
+	}
+	if _, err := i.validateVote(in.GetVote()); err != nil {
+		return nil, err
+	}
+	if move, ok := i.playerToMove[in.GetVote().GetPlayerId()]; ok {
+		if move != in.GetVote().GetChessVote().GetMove() {
+			if err := i.checkVoteChange(); err != nil {
+				return nil, err
+			}
+		}
+		i.moveToCount[move]--
+		if i.moveToCount[move] <= 0 {
+			delete(i.moveToCount, move)
+		}
+	}
+	i.playerToMove[in.GetVote().GetPlayerId()] = in.GetVote().GetChessVote().GetMove()
+	i.moveToCount[in.GetVote().GetChessVote().GetMove()]++
+	delete(i.retracted, in.GetVote().GetPlayerId())
+	i.voteChanged(in.GetVote().GetPlayerId())
+	// Once merged by the master the tally only changes with the master's next merge.
+	if !i.merged() {
+		i.publish(pb.WatchGameResponse_TALLY_UPDATED, nil)
+	}
+	return &pb.PostVoteResponse{}, nil
+}
+
+// RetractVote removes the player's vote of the current round from this server, if the game's rules allow it.
+func (i *Implementation) RetractVote(ctx context.Context, in *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	if i.metadata.GetRules().GetVoteAppliedImmediately() != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "votes are applied immediately by the master and cannot be retracted")
+	}
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+	if !i.acceptingVotes {
+		return nil, status.Errorf(codes.FailedPrecondition, "round %d is not accepting votes", i.roundIndex)
+	}
+	if in.GetRoundIndex() != i.roundIndex {
+		return nil, status.Errorf(codes.InvalidArgument, "bad round index %d; current round %d", in.GetRoundIndex(), i.roundIndex)
+	}
+	move, ok := i.playerToMove[in.GetPlayerId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "player %s has not voted in round %d on this server", in.GetPlayerId(), i.roundIndex)
+	}
+	if err := i.checkVoteChange(); err != nil {
+		return nil, err
+	}
+	i.retractVote(in.GetPlayerId())
+	if !i.merged() {
+		i.publish(pb.WatchGameResponse_TALLY_UPDATED, nil)
+	}
+	return &pb.RetractVoteResponse{
+		Vote: &messages.Vote{
+			PlayerId: in.GetPlayerId(),
 			GameVote: &messages.Vote_ChessVote{
 				ChessVote: &games.ChessVote{
 					RoundIndex: i.roundIndex,
-					Move:       m,
+					Move:       move,
 				},
 			},
-		})
+		},
+	}, nil
+}
+
+// checkVoteChange returns a GRPC status error if the game's rules do not allow a vote to be changed or retracted now.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) checkVoteChange() error {
+	rules := i.metadata.GetRules()
+	switch rules.GetVoteChanges() {
+	case messages.Game_Metadata_Rules_FINAL_VOTES:
+		return status.Errorf(codes.FailedPrecondition, "votes are final once cast")
+	case messages.Game_Metadata_Rules_LOCKED_VOTES:
+		lock := i.endTime.Add(-time.Duration(rules.GetVoteLockSeconds()) * time.Second)
+		if !time.Now().Before(lock) {
+			return status.Errorf(codes.FailedPrecondition, "votes are locked %d seconds before the round ends", rules.GetVoteLockSeconds())
+		}
 	}
+	return nil
+}
 
-	return &pb.GetVotesResponse{
-		Complete:   !i.acceptingVotes,
-		RoundIndex: i.roundIndex,
-		Votes:      votes,
+// ApplyVote is called by a GameServerSlave to apply a vote when votes are applied immediately.
+// The first valid vote for a round is applied, any later vote for that round is rejected as Aborted.
+func (i *Implementation) ApplyVote(ctx context.Context, in *pb.ApplyVoteRequest) (*pb.ApplyVoteResponse, error) {
+	i.gameMux.Lock()
+	defer i.gameMux.Unlock()
+	i.teamsMux.Lock()
+	defer i.teamsMux.Unlock()
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	rules := i.metadata.GetRules().GetVoteAppliedImmediately()
+	if rules == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "game votes are not applied immediately")
+	}
+	if i.result != nil {
+		return nil, errGameEnded
+	}
+	if r := in.GetVote().GetChessVote().GetRoundIndex(); r < i.roundIndex {
+		return nil, status.Errorf(codes.Aborted, "a vote was already applied for round %d; current round %d", r, i.roundIndex)
+	} else if r > i.roundIndex {
+		return nil, status.Errorf(codes.InvalidArgument, "bad round index %d; current round %d", r, i.roundIndex)
+	}
+	m, err := i.validateVote(in.GetVote())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	whiteTurn := i.game.Position().Turn() == ch.White
+	cooldown := time.Duration(rules.GetCooldownSeconds()) * time.Second
+	if wait := i.teamToLastMove[whiteTurn].Add(cooldown).Sub(now); wait > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "team %s is cooling down for %v", i.game.Position().Turn(), wait)
+	}
+
+	closed := &games.ChessState{
+		WhiteTeamCount: i.teamToCount[true],
+		BlackTeamCount: i.teamToCount[false],
+		BoardFen:       i.game.FEN(),
+		MoveToCount:    map[string]int64{in.GetVote().GetChessVote().GetMove(): 1},
+		MoveNotations:  moveNotations(i.game.Position(), map[string]int64{in.GetVote().GetChessVote().GetMove(): 1}),
+		RoundStartTime: i.startTime.UnixNano(),
+		RoundEndTime:   now.UnixNano(),
+		RoundIndex:     i.roundIndex,
+		Result: &games.ChessRoundResult{
+			Move:     in.GetVote().GetChessVote().GetMove(),
+			PlayerId: in.GetVote().GetPlayerId(),
+		},
+	}
+	if err := i.game.Move(m); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed applying move %s: %v", in.GetVote().GetChessVote().GetMove(), err)
+	}
+	i.history.StateHistory = append(i.history.GetStateHistory(), closed)
+	i.teamToLastMove[whiteTurn] = now
+	i.checkGameOver(closed.GetRoundIndex(), now)
+
+	i.roundIndex++
+	i.startTime = now
+	i.endTime = time.Time{}
+	i.playerToMove = map[string]string{}
+	i.moveToCount = map[string]int64{}
+	i.resetVoteSeqs()
+	i.version++
+	i.publishRoundClosed(closed)
+
+	return &pb.ApplyVoteResponse{
+		State: i.state(true),
 	}, nil
 }
 
-// PostVote posts a vote to this game.
-func (i *Implementation) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
-	if in.GetVote().GetChessVote().GetRoundIndex() != i.roundIndex {
-		return nil, status.Errorf(codes.InvalidArgument, "bad round index %d; current round %d", in.GetVote().GetChessVote().GetRoundIndex(), i.roundIndex)
+// retractVote removes the player's vote of the current round from this server, if any.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) retractVote(playerID string) {
+	move, ok := i.playerToMove[playerID]
+	if !ok {
+		return
+	}
+	delete(i.playerToMove, playerID)
+	i.moveToCount[move]--
+	if i.moveToCount[move] <= 0 {
+		delete(i.moveToCount, move)
+	}
+	i.retracted[playerID] = true
+	i.voteChanged(playerID)
+}
+
+// retractDepartedVotes retracts the votes of players that are no longer in the game.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) retractDepartedVotes() {
+	for p := range i.playerToMove {
+		if _, ok := i.playerToTeam[p]; !ok {
+			i.retractVote(p)
+		}
+	}
+}
+
+// voteChanged records the player's vote was added, changed or retracted for GetVotes cursors.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) voteChanged(playerID string) {
+	i.voteSeq++
+	i.playerToVoteSeq[playerID] = i.voteSeq
+}
+
+// resetVoteSeqs starts tracking the changes to the current round's votes, cursors of earlier rounds then get every vote.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) resetVoteSeqs() {
+	i.voteSeq++
+	i.roundVoteSeq = i.voteSeq
+	i.playerToVoteSeq = make(map[string]int64, len(i.playerToMove))
+	for p := range i.playerToMove {
+		i.playerToVoteSeq[p] = i.voteSeq
 	}
-	t, ok := i.playerToTeam[in.GetVote().GetPlayerId()]
+	i.retracted = map[string]bool{}
+}
+
+// ReportVoters is called by a GameServerSlave to report the players that voted on it this round, or retracted their vote.
+func (i *Implementation) ReportVoters(ctx context.Context, in *pb.ReportVotersRequest) (*pb.ReportVotersResponse, error) {
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	for _, v := range in.GetVotes() {
+		if v.GetChessVote().GetRoundIndex() != i.roundIndex {
+			continue
+		}
+		i.roundVoters[v.GetPlayerId()] = in.GetSlaveId()
+	}
+	for _, v := range in.GetRetracted() {
+		if v.GetChessVote().GetRoundIndex() == i.roundIndex && i.roundVoters[v.GetPlayerId()] == in.GetSlaveId() {
+			delete(i.roundVoters, v.GetPlayerId())
+		}
+	}
+	return &pb.ReportVotersResponse{}, nil
+}
+
+// DropSlaveVoters forgets the players the slave reported as having voted this round.
+func (i *Implementation) DropSlaveVoters(slaveID string) {
+	i.moveMux.Lock()
+	defer i.moveMux.Unlock()
+
+	for p, id := range i.roundVoters {
+		if id == slaveID {
+			delete(i.roundVoters, p)
+		}
+	}
+}
+
+// positionHash returns the fingerprint of the board FEN votes are cast for, see ChessVote.position_hash.
+func positionHash(fen string) []byte {
+	sum := sha256.Sum256([]byte(fen))
+	return sum[:]
+}
+
+// validateVote checks the vote's player may vote this round for the current position, replaces the vote's move with its canonical key and
+// returns the decoded move.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) validateVote(v *messages.Vote) (*ch.Move, error) {
+	t, ok := i.playerToTeam[v.GetPlayerId()]
 	if !ok {
-		return nil, status.Errorf(codes.PermissionDenied, "player %s has not joined this game", in.GetVote().GetPlayerId())
+		return nil, status.Errorf(codes.PermissionDenied, "player %s has not joined this game", v.GetPlayerId())
 	}
 	if t != (i.game.Position().Turn() == ch.White) {
-		return nil, status.Errorf(codes.PermissionDenied, "player %s is not part of team: %s", in.GetVote().GetPlayerId(), i.game.Position().Turn())
+		return nil, status.Errorf(codes.PermissionDenied, "player %s is not part of team: %s", v.GetPlayerId(), i.game.Position().Turn())
 	}
-	_, err := ch.AlgebraicNotation{}.Decode(i.game.Position(), in.GetVote().GetChessVote().GetMove())
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid move %s: %v", in.GetVote().GetChessVote().GetMove(), err)
+	h := v.GetChessVote().GetPositionHash()
+	if len(h) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "missing position hash of the board the vote was cast for")
 	}
-	i.moveMux.Lock()
-	defer i.moveMux.Lock()
-	if move, ok := i.playerToMove[in.GetVote().GetPlayerId()]; ok {
-		i.moveToCount[move]--
+	if !bytes.Equal(h, positionHash(i.game.FEN())) {
+		// Aborted rather than FailedPrecondition, which votes get when they cannot be cast at all, so clients know to
+		// refresh the board and vote again.
+		return nil, status.Errorf(codes.Aborted, "vote was cast for another position than the current board %s, refresh the game state", i.game.FEN())
 	}
-	i.playerToMove[in.GetVote().GetPlayerId()] = in.GetVote().GetChessVote().GetMove()
-	i.moveToCount[in.GetVote().GetChessVote().GetMove()]++
-	return &pb.PostVoteResponse{}, nil
+	m := decodeMove(i.game.Position(), v.GetChessVote().GetMove())
+	if m == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid move %s: not a valid move in standard algebraic, long algebraic or UCI notation", v.GetChessVote().GetMove())
+	}
+	v.GetChessVote().Move = canonicalMove(i.game.Position(), m)
+	return m, nil
 }
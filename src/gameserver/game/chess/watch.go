@@ -0,0 +1,177 @@
+package chess
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// watchBufferSize is how many events a watcher may fall behind before it is dropped.
+const watchBufferSize = 64
+
+// watchers fans events out to every WatchGame stream. Each stream has its own buffer so a slow
+// client never blocks the game, instead it is dropped once its buffer is full.
+type watchers struct {
+	mux  sync.Mutex
+	next int
+	subs map[int]chan *pb.WatchGameResponse
+}
+
+// subscribe returns a new subscription ID and the channel its events are sent on.
+func (w *watchers) subscribe() (int, <-chan *pb.WatchGameResponse) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.subs == nil {
+		w.subs = map[int]chan *pb.WatchGameResponse{}
+	}
+	w.next++
+	ch := make(chan *pb.WatchGameResponse, watchBufferSize)
+	w.subs[w.next] = ch
+	return w.next, ch
+}
+
+// unsubscribe stops sending events to the subscription, if still subscribed.
+func (w *watchers) unsubscribe(id int) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if ch, ok := w.subs[id]; ok {
+		close(ch)
+		delete(w.subs, id)
+	}
+}
+
+// publish sends the event to every subscription without blocking. Subscriptions with a full buffer
+// are closed and removed.
+func (w *watchers) publish(e *pb.WatchGameResponse) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	for id, ch := range w.subs {
+		select {
+		case ch <- e:
+		default:
+			close(ch)
+			delete(w.subs, id)
+		}
+	}
+}
+
+// WatchGame streams every change to this game as seen by this server, starting with a snapshot of the current state.
+func (i *Implementation) WatchGame(in *pb.WatchGameRequest, stream pb.GameServer_WatchGameServer) error {
+	// Subscribe while holding the game's locks so no event between the snapshot and the subscription is lost.
+	i.gameMux.Lock()
+	if !i.initialized {
+		i.gameMux.Unlock()
+		return status.Error(codes.FailedPrecondition, "game not initialized")
+	}
+	i.teamsMux.Lock()
+	i.moveMux.Lock()
+	id, events := i.watchers.subscribe()
+	snapshot := i.event(pb.WatchGameResponse_SNAPSHOT, nil)
+	i.moveMux.Unlock()
+	i.teamsMux.Unlock()
+	i.gameMux.Unlock()
+	defer i.watchers.unsubscribe(id)
+
+	if err := stream.Send(snapshot); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell too far behind, watch again")
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publish sends an event holding the current state to every watcher. closed is only set for ROUND_CLOSED events.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) publish(event pb.WatchGameResponse_Event, closed *games.ChessState) {
+	i.watchers.publish(i.event(event, closed))
+}
+
+// event builds an event holding a copy of the current state.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) event(event pb.WatchGameResponse_Event, closed *games.ChessState) *pb.WatchGameResponse {
+	moveToCount, tallyTime := i.liveTally()
+	e := &pb.WatchGameResponse{
+		Event: event,
+		Time:  time.Now().UnixNano(),
+		State: &messages.Game_State{
+			Version: i.version,
+			Game: &messages.Game_State_ChessState{
+				ChessState: &games.ChessState{
+					WhiteTeamCount:      i.teamToCount[true],
+					BlackTeamCount:      i.teamToCount[false],
+					BoardFen:            i.game.FEN(),
+					MoveToCount:         moveToCount,
+					MoveNotations:       moveNotations(i.game.Position(), moveToCount),
+					RoundStartTime:      i.startTime.UnixNano(),
+					RoundEndTime:        i.endTime.UnixNano(),
+					RoundIndex:          i.roundIndex,
+					SelectionSeedHash:   i.selectionSeedHash,
+					GameResult:          i.result,
+					RebalancedPlayerIds: append([]string(nil), i.rebalanced...),
+					TallyTime:           tallyTime,
+				},
+			},
+		},
+	}
+	if closed != nil {
+		e.ClosedRound = &messages.Game_State{
+			Game: &messages.Game_State_ChessState{
+				ChessState: closed,
+			},
+		}
+	}
+	return e
+}
+
+// publishRoundClosed publishes the closed round followed by either the game ending or the next round opening.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) publishRoundClosed(closed *games.ChessState) {
+	i.publish(pb.WatchGameResponse_ROUND_CLOSED, closed)
+	if i.result != nil {
+		i.publish(pb.WatchGameResponse_GAME_ENDED, nil)
+		return
+	}
+	i.publish(pb.WatchGameResponse_ROUND_OPENED, nil)
+}
+
+// publishStateChanges publishes the events between the passed old state and the current state after an update from the master.
+// This function is NON-LOCKING so wrap it in a mux if necessary.
+func (i *Implementation) publishStateChanges(oldRound int32, oldEndTime time.Time, oldResult *games.ChessGameResult, oldWhite, oldBlack int64) {
+	if i.teamToCount[true] != oldWhite || i.teamToCount[false] != oldBlack {
+		i.publish(pb.WatchGameResponse_PLAYERS_CHANGED, nil)
+	}
+	if i.roundIndex != oldRound {
+		var closed *games.ChessState
+		for _, s := range i.history.GetStateHistory() {
+			if s.GetRoundIndex() == oldRound {
+				closed = s
+			}
+		}
+		i.publishRoundClosed(closed)
+		return
+	}
+	if oldResult == nil && i.result != nil {
+		i.publish(pb.WatchGameResponse_GAME_ENDED, nil)
+		return
+	}
+	if !i.endTime.Equal(oldEndTime) {
+		i.publish(pb.WatchGameResponse_ROUND_OPENED, nil)
+	}
+}
@@ -2,6 +2,9 @@
 package game
 
 import (
+	"context"
+	"time"
+
 	"github.com/sambdavidson/community-chess/src/gameserver/game/chess"
 	"github.com/sambdavidson/community-chess/src/gameserver/game/noop"
 	"github.com/sambdavidson/community-chess/src/proto/messages"
@@ -13,14 +16,69 @@ type Implementation interface {
 	pb.GameServerServer
 	pb.GameServerMasterServer
 	pb.GameServerSlaveServer
+	RoundCloser
+	PlayerRestorer
+	SecretHolder
+}
+
+// RoundCloser is used by a GameServerMaster to close rounds of votes that are applied after a tally.
+type RoundCloser interface {
+	// RoundEndTime returns the time the current round is scheduled to close.
+	RoundEndTime() time.Time
+
+	// Finished returns whether the game has ended and no longer accepts votes.
+	Finished() bool
+
+	// AllVoted returns whether every player able to vote this round has voted on any server of this game.
+	AllVoted() bool
+
+	// CloseRound tallies the votes gathered from every server of this game for the current round, applies the
+	// selected move and opens the next round. Returns the new state of the game.
+	CloseRound(ctx context.Context, votes []*messages.Vote) (*messages.Game_State, error)
+
+	// DropSlaveVoters forgets the players the slave reported as having voted this round, used once the slave is removed.
+	DropSlaveVoters(slaveID string)
+
+	// VotesFromTally returns the votes of a slave's tally of the round, matching its player digests to the players
+	// of this game. Digests of players that have not joined are dropped.
+	VotesFromTally(roundIndex int32, tally *pb.VoteTally) ([]*messages.Vote, error)
+
+	// CountVotes returns how many of the votes gathered from every server of this game chose each move, counting
+	// only the votes CloseRound would count. Used to merge the live tally sent to every server with UpdateTally.
+	CountVotes(votes []*messages.Vote) map[string]int64
+}
+
+// PlayerRestorer is used by a GameServerSlave to re-add players to a new master that does not know them,
+// so players do not need to rejoin after the master restarts.
+type PlayerRestorer interface {
+	// MissingPlayers returns requests re-adding every player known to this server but missing from the game.
+	MissingPlayers(game *messages.Game) []*pb.AddPlayersRequest_NewPlayer
+}
+
+// SecretHolder is used by a GameServerMaster to persist state only it knows so it survives a restart.
+type SecretHolder interface {
+	// Secret returns the state only known by the master, or nil if there is none.
+	Secret() []byte
+
+	// RestoreSecret restores state returned by Secret before the master restarted.
+	RestoreSecret(secret []byte) error
 }
 
 var (
 	// Noop is an instanciated no-op game implementation.
 	Noop Implementation = &noop.Implementation{}
 
-	// ImplementationMap maps game types to their implementations.
-	ImplementationMap = map[messages.Game_Type]Implementation{
-		messages.Game_CHESS: &chess.Implementation{},
+	// ImplementationMap maps game types to constructors of their implementations.
+	ImplementationMap = map[messages.Game_Type]func() Implementation{
+		messages.Game_CHESS: func() Implementation { return &chess.Implementation{} },
 	}
 )
+
+// NewImplementation returns a new implementation of the game type, one per game. Returns false if the type is unknown.
+func NewImplementation(t messages.Game_Type) (Implementation, bool) {
+	newImpl, ok := ImplementationMap[t]
+	if !ok {
+		return nil, false
+	}
+	return newImpl(), true
+}
@@ -3,7 +3,9 @@ package noop
 
 import (
 	"context"
+	"time"
 
+	"github.com/sambdavidson/community-chess/src/proto/messages"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -82,6 +84,26 @@ func (i *Implementation) Status(ctx context.Context, in *pb.StatusRequest) (*pb.
 	return nil, err
 }
 
+// WatchGame returns FailedPrecondition for everything.
+func (i *Implementation) WatchGame(in *pb.WatchGameRequest, stream pb.GameServer_WatchGameServer) error {
+	return err
+}
+
+// Resync returns FailedPrecondition for everything.
+func (i *Implementation) Resync(ctx context.Context, in *pb.ResyncRequest) (*pb.ResyncResponse, error) {
+	return nil, err
+}
+
+// Heartbeat returns FailedPrecondition for everything.
+func (i *Implementation) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	return nil, err
+}
+
+// RemoveSlave returns FailedPrecondition for everything.
+func (i *Implementation) RemoveSlave(ctx context.Context, in *pb.RemoveSlaveRequest) (*pb.RemoveSlaveResponse, error) {
+	return nil, err
+}
+
 // StopGame returns FailedPrecondition for everything.
 func (i *Implementation) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
 	return nil, err
@@ -101,3 +123,101 @@ func (i *Implementation) GetVotes(ctx context.Context, in *pb.GetVotesRequest) (
 func (i *Implementation) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
 	return nil, err
 }
+
+// RetractVote returns FailedPrecondition for everything.
+func (i *Implementation) RetractVote(ctx context.Context, in *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	return nil, err
+}
+
+// ApplyVote returns FailedPrecondition for everything.
+func (i *Implementation) ApplyVote(ctx context.Context, in *pb.ApplyVoteRequest) (*pb.ApplyVoteResponse, error) {
+	return nil, err
+}
+
+// ReportVoters returns FailedPrecondition for everything.
+func (i *Implementation) ReportVoters(ctx context.Context, in *pb.ReportVotersRequest) (*pb.ReportVotersResponse, error) {
+	return nil, err
+}
+
+// RoundEndTime returns the zero time.
+func (i *Implementation) RoundEndTime() time.Time {
+	return time.Time{}
+}
+
+// CloseRound returns FailedPrecondition for everything.
+func (i *Implementation) CloseRound(ctx context.Context, votes []*messages.Vote) (*messages.Game_State, error) {
+	return nil, err
+}
+
+// AllVoted returns false.
+func (i *Implementation) AllVoted() bool {
+	return false
+}
+
+// Finished returns false.
+func (i *Implementation) Finished() bool {
+	return false
+}
+
+// DropSlaveVoters does nothing.
+func (i *Implementation) DropSlaveVoters(slaveID string) {}
+
+// VotesFromTally returns FailedPrecondition for everything.
+func (i *Implementation) VotesFromTally(roundIndex int32, tally *pb.VoteTally) ([]*messages.Vote, error) {
+	return nil, err
+}
+
+// CountVotes returns nil.
+func (i *Implementation) CountVotes(votes []*messages.Vote) map[string]int64 {
+	return nil
+}
+
+// MissingPlayers returns nil.
+func (i *Implementation) MissingPlayers(game *messages.Game) []*pb.AddPlayersRequest_NewPlayer {
+	return nil
+}
+
+// Secret returns nil.
+func (i *Implementation) Secret() []byte {
+	return nil
+}
+
+// RestoreSecret returns FailedPrecondition for everything.
+func (i *Implementation) RestoreSecret(secret []byte) error {
+	return err
+}
+
+// CreateInvite returns FailedPrecondition for everything.
+func (i *Implementation) CreateInvite(ctx context.Context, in *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	return nil, err
+}
+
+// RevokeInvite returns FailedPrecondition for everything.
+func (i *Implementation) RevokeInvite(ctx context.Context, in *pb.RevokeInviteRequest) (*pb.RevokeInviteResponse, error) {
+	return nil, err
+}
+
+// UpdateAllowList returns FailedPrecondition for everything.
+func (i *Implementation) UpdateAllowList(ctx context.Context, in *pb.UpdateAllowListRequest) (*pb.UpdateAllowListResponse, error) {
+	return nil, err
+}
+
+// UpdateAccess returns FailedPrecondition for everything.
+func (i *Implementation) UpdateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	return nil, err
+}
+
+// SwitchTeam returns FailedPrecondition for everything.
+func (i *Implementation) SwitchTeam(ctx context.Context, in *pb.SwitchTeamRequest) (*pb.SwitchTeamResponse, error) {
+	return nil, err
+}
+
+// SwitchPlayerTeam returns FailedPrecondition for everything.
+func (i *Implementation) SwitchPlayerTeam(ctx context.Context, in *pb.SwitchPlayerTeamRequest) (*pb.SwitchPlayerTeamResponse, error) {
+	return nil, err
+}
+
+// UpdateTally returns FailedPrecondition for everything.
+func (i *Implementation) UpdateTally(ctx context.Context, in *pb.UpdateTallyRequest) (*pb.UpdateTallyResponse, error) {
+	return nil, err
+}
@@ -0,0 +1,212 @@
+package gamemaster
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// inviteCodeBytes is the number of random bytes in an invite code.
+const inviteCodeBytes = 12
+
+// UpdateAccess is called by a GameServerSlave to change the invites or allow-list on behalf of a player.
+func (s *GameServerMaster) UpdateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	if _, err := s.registeredSlave(ctx); err != nil {
+		return nil, err
+	}
+	return s.updateAccess(ctx, in)
+}
+
+// updateAccess changes the invites or allow-list if the player is the game's creator and saves the game.
+func (s *GameServerMaster) updateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	if !s.c.isLeader() {
+		return nil, status.Errorf(codes.Unavailable, "this master is a standby, the game is led by %s", s.c.leaderID())
+	}
+	res, err := s.changeAccess(in)
+	if err != nil {
+		return nil, err
+	}
+	// Access changes do not change the state's version so they are snapshotted rather than logged.
+	s.saveSnapshot(ctx)
+	return res, nil
+}
+
+// changeAccess applies the change of updateAccess.
+func (s *GameServerMaster) changeAccess(in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	if in.GetPlayerId() == "" || in.GetPlayerId() != s.access.GetCreatorId() {
+		return nil, status.Errorf(codes.PermissionDenied, "only the game's creator may change who can join it")
+	}
+	now := time.Now()
+	s.pruneInvites(now)
+
+	res := &pb.UpdateAccessResponse{}
+	switch change := in.GetChange().(type) {
+	case *pb.UpdateAccessRequest_CreateInvite:
+		expire := change.CreateInvite.GetExpireTime()
+		if expire != 0 && expire <= now.UnixNano() {
+			return nil, status.Errorf(codes.InvalidArgument, "invite would already be expired")
+		}
+		code, err := newInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		res.Invite = &messages.GameAccess_Invite{
+			Code:       code,
+			ExpireTime: expire,
+		}
+		s.access.Invites = append(s.access.Invites, res.Invite)
+	case *pb.UpdateAccessRequest_RevokeInvite:
+		invites := s.access.GetInvites()
+		for i, invite := range invites {
+			if invite.GetCode() == change.RevokeInvite.GetCode() {
+				s.access.Invites = append(invites[:i:i], invites[i+1:]...)
+				return &pb.UpdateAccessResponse{Access: proto.Clone(s.access).(*messages.GameAccess)}, nil
+			}
+		}
+		return nil, status.Errorf(codes.NotFound, "no invite with code %q", change.RevokeInvite.GetCode())
+	case *pb.UpdateAccessRequest_UpdateAllowList:
+		s.allowPlayersLocked(change.UpdateAllowList.GetAllowPlayerIds())
+		disallow := map[string]bool{}
+		for _, id := range change.UpdateAllowList.GetDisallowPlayerIds() {
+			disallow[id] = true
+		}
+		allowed := []string{}
+		for _, id := range s.access.GetAllowedPlayerIds() {
+			if !disallow[id] {
+				allowed = append(allowed, id)
+			}
+		}
+		s.access.AllowedPlayerIds = allowed
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "missing access change")
+	}
+	res.Access = proto.Clone(s.access).(*messages.GameAccess)
+	return res, nil
+}
+
+// admit checks every joining player may join the game and returns those joining with an invite code, who are
+// allowed once they have joined. Every player may join open games.
+// If anything goes wrong returns a GRPC status error.
+func (s *GameServerMaster) admit(ctx context.Context, in *pb.AddPlayersRequest) ([]string, error) {
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if metadataRes.GetMetadata().GetVisibility() != messages.Game_Metadata_INVITE_ONLY {
+		return nil, nil
+	}
+
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	s.pruneInvites(time.Now())
+	var redeemed []string
+	for _, p := range in.GetPlayers() {
+		switch {
+		case s.allowed(p.GetPlayerId()):
+		case s.validInvite(p.GetRequest().GetInviteCode()):
+			redeemed = append(redeemed, p.GetPlayerId())
+		default:
+			return nil, status.Errorf(codes.PermissionDenied, "game is invite only, player %s needs a valid invite code", p.GetPlayerId())
+		}
+	}
+	return redeemed, nil
+}
+
+// allowPlayers adds the players to the allow-list.
+func (s *GameServerMaster) allowPlayers(playerIDs []string) {
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	s.allowPlayersLocked(playerIDs)
+}
+
+// gameAccess returns a copy of who may join the game.
+func (s *GameServerMaster) gameAccess() *messages.GameAccess {
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	return proto.Clone(s.access).(*messages.GameAccess)
+}
+
+// setGameAccess replaces who may join the game.
+func (s *GameServerMaster) setGameAccess(access *messages.GameAccess) {
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	s.access = &messages.GameAccess{}
+	if access != nil {
+		s.access = proto.Clone(access).(*messages.GameAccess)
+	}
+}
+
+// allowPlayersLocked adds the players to the allow-list, keeping it sorted and without duplicates.
+// Callers must hold accessMux.
+func (s *GameServerMaster) allowPlayersLocked(playerIDs []string) {
+	if len(playerIDs) == 0 {
+		return
+	}
+	allowed := map[string]bool{}
+	for _, id := range append(s.access.GetAllowedPlayerIds(), playerIDs...) {
+		allowed[id] = true
+	}
+	s.access.AllowedPlayerIds = make([]string, 0, len(allowed))
+	for id := range allowed {
+		s.access.AllowedPlayerIds = append(s.access.AllowedPlayerIds, id)
+	}
+	sort.Strings(s.access.AllowedPlayerIds)
+}
+
+// allowed returns whether the player may join without an invite code. Callers must hold accessMux.
+func (s *GameServerMaster) allowed(playerID string) bool {
+	if playerID == s.access.GetCreatorId() {
+		return true
+	}
+	for _, id := range s.access.GetAllowedPlayerIds() {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// validInvite returns whether the code is an unexpired invite. Callers must hold accessMux and prune invites first.
+func (s *GameServerMaster) validInvite(code string) bool {
+	if code == "" {
+		return false
+	}
+	for _, invite := range s.access.GetInvites() {
+		if subtle.ConstantTimeCompare([]byte(invite.GetCode()), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneInvites removes every invite expired by now. Callers must hold accessMux.
+func (s *GameServerMaster) pruneInvites(now time.Time) {
+	invites := s.access.GetInvites()[:0]
+	for _, invite := range s.access.GetInvites() {
+		if invite.GetExpireTime() == 0 || invite.GetExpireTime() > now.UnixNano() {
+			invites = append(invites, invite)
+		}
+	}
+	s.access.Invites = invites
+}
+
+// newInviteCode returns a random URL safe invite code.
+func newInviteCode() (string, error) {
+	b := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", status.Errorf(codes.Internal, "unable to generate invite code: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
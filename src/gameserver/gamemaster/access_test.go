@@ -0,0 +1,118 @@
+package gamemaster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+func TestInviteOnlyJoin(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFileStore(t)
+	c := newTestMaster(t, "m1", fs)
+	g := testGame()
+	g.Metadata.Visibility = messages.Game_Metadata_INVITE_ONLY
+	if _, err := c.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{
+		Game:   g,
+		Access: &messages.GameAccess{CreatorId: "creator", AllowedPlayerIds: []string{"friend"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"creator", "friend"} {
+		if err := testJoin(c, id, ""); err != nil {
+			t.Errorf("got error %v joining as %s; want the creator and allowed players to join", err, id)
+		}
+	}
+	if err := testJoin(c, "stranger", ""); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v joining without an invite; want PermissionDenied", err)
+	}
+	if _, err := testUpdateAccess(c, "friend", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_CreateInvite{CreateInvite: &pb.CreateInviteRequest{}}}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v creating an invite as a player; want PermissionDenied", err)
+	}
+
+	res, err := testUpdateAccess(c, "creator", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_CreateInvite{CreateInvite: &pb.CreateInviteRequest{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := res.GetInvite().GetCode()
+	if err := testJoin(c, "stranger", "wrong"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v joining with a wrong invite code; want PermissionDenied", err)
+	}
+	if err := testJoin(c, "stranger", code); err != nil {
+		t.Errorf("got error %v joining with an invite code", err)
+	}
+	snapshot, err := fs.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed := snapshot.GetAccess().GetAllowedPlayerIds(); len(allowed) != 2 || allowed[1] != "stranger" {
+		t.Errorf("got players %v allowed in the game store; want the player that redeemed the invite allowed", allowed)
+	}
+
+	if _, err := testUpdateAccess(c, "creator", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_RevokeInvite{RevokeInvite: &pb.RevokeInviteRequest{Code: code}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testJoin(c, "late", code); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v joining with a revoked invite code; want PermissionDenied", err)
+	}
+	if _, err := testUpdateAccess(c, "creator", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_RevokeInvite{RevokeInvite: &pb.RevokeInviteRequest{Code: code}}}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v revoking a revoked invite; want NotFound", err)
+	}
+
+	res, err = testUpdateAccess(c, "creator", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_CreateInvite{CreateInvite: &pb.CreateInviteRequest{
+		ExpireTime: time.Now().Add(50 * time.Millisecond).UnixNano(),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := testJoin(c, "late", res.GetInvite().GetCode()); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v joining with an expired invite code; want PermissionDenied", err)
+	}
+
+	res, err = testUpdateAccess(c, "creator", &pb.UpdateAccessRequest{Change: &pb.UpdateAccessRequest_UpdateAllowList{UpdateAllowList: &pb.UpdateAllowListRequest{
+		AllowPlayerIds:    []string{"late"},
+		DisallowPlayerIds: []string{"friend"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed := res.GetAccess().GetAllowedPlayerIds(); len(allowed) != 2 || allowed[0] != "late" || allowed[1] != "stranger" {
+		t.Errorf("got players %v allowed; want [late stranger]", allowed)
+	}
+	if err := testJoin(c, "late", ""); err != nil {
+		t.Errorf("got error %v joining once allowed", err)
+	}
+}
+
+func testJoin(c *Controller, playerID, inviteCode string) error {
+	_, err := c.GameServerMasterInstance().addPlayers(context.Background(), "", &pb.AddPlayersRequest{
+		Players: []*pb.AddPlayersRequest_NewPlayer{
+			&pb.AddPlayersRequest_NewPlayer{
+				PlayerId: playerID,
+				Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+					Fields: &messages.Game_NewPlayerFields{
+						Game: &messages.Game_NewPlayerFields_ChessFields{
+							ChessFields: &games.ChessNewPlayerFields{WhiteTeam: true},
+						},
+					},
+					InviteCode: inviteCode,
+				},
+			},
+		},
+	})
+	return err
+}
+
+func testUpdateAccess(c *Controller, playerID string, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	in.PlayerId = playerID
+	return c.GameServerMasterInstance().updateAccess(context.Background(), in)
+}
@@ -1,13 +1,17 @@
 package gamemaster
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/sambdavidson/community-chess/src/proto/messages"
 
 	"github.com/sambdavidson/community-chess/src/gameserver/game"
+	"github.com/sambdavidson/community-chess/src/gameserver/store"
 	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
 	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
 	"google.golang.org/grpc"
@@ -20,46 +24,123 @@ type Opts struct {
 	ServerTLSConfig     *tls.Config
 	MasterTLSConfig     *tls.Config
 	PlayersRegistrarCli pr.PlayersRegistrarClient
+	// Store persists the game so a master restarted with the same GameID reloads it. If nil the game is not persisted.
+	Store store.Store
+	// Leases elects the leader out of several masters sharing Store. If nil this master always leads.
+	Leases store.Leases
+	// LeaseDuration is how long the leader's lease lasts unless renewed. Defaults to 10 seconds.
+	LeaseDuration time.Duration
+	// Address of this master's GameServerMaster service, advertised in its lease.
+	Address string
+	// OnStop is called once the game has been stopped so the surrounding process can shut down.
+	OnStop func()
+	// AggregateVotes has slaves send a tally of their votes when a round closes instead of every vote.
+	AggregateVotes bool
+	// TallyRefreshInterval is how often the votes of every slave are merged into the live tally sent to all servers.
+	// If zero each server only shows the votes it received.
+	TallyRefreshInterval time.Duration
 }
 
-// Controller owns both the GameServer and GameServerMaster and manages their game data.
+// Controller owns both the GameServer and GameServerMaster of one game and manages their game data.
+// A process may run any number of controllers, one per game.
 type Controller struct {
 	gameServer       *GameServer
 	gameServerMaster *GameServerMaster
 
-	slaveConns []*grpc.ClientConn
-}
-
-var (
 	instanceID         string
 	gameID             string
 	gameType           messages.Game_Type
-	gameImplementation = game.Noop
+	gameImplementation game.Implementation
 	initializeTime     time.Time
-	controller         *Controller
 	masterTLSConfig    *tls.Config
-)
+	gameStore          store.Store
+	aggregateVotes     bool
+	tallyRefresh       time.Duration
+
+	// Slave ID to its connection, guarded by the GameServerMaster's mux.
+	slaveConns map[string]*grpc.ClientConn
+
+	// Closed to stop the round runner, tally merger, slave monitor and campaign.
+	stop     chan struct{}
+	stopOnce sync.Once
+	onStop   func()
+	// Running round runner, tally merger, slave monitor and campaign.
+	workers sync.WaitGroup
 
-// NewGameMasterController todo
+	leases        store.Leases
+	leaseDuration time.Duration
+	address       string
+
+	leaseMux sync.Mutex
+	// Whether this master holds the lease and runs the game.
+	leading     bool
+	leaseExpire time.Time
+	// Latest known lease, held by the leader.
+	leader *messages.GameLease
+	// Latest copy of the game saved by the leader, kept while standing by.
+	warm *messages.GameSnapshot
+}
+
+// NewGameMasterController builds the master of the game opts.GameID.
 func NewGameMasterController(opts Opts) (*Controller, error) {
-	if controller != nil {
-		return nil, fmt.Errorf("GameMaster Controller already initialized")
+	controller := &Controller{
+		instanceID:         opts.InstanceID,
+		gameID:             opts.GameID,
+		gameImplementation: game.Noop,
+		masterTLSConfig:    opts.MasterTLSConfig,
+		gameStore:          opts.Store,
+		slaveConns:         map[string]*grpc.ClientConn{},
+		stop:               make(chan struct{}),
+		onStop:             opts.OnStop,
+		leases:             opts.Leases,
+		leaseDuration:      opts.LeaseDuration,
+		address:            opts.Address,
+		aggregateVotes:     opts.AggregateVotes,
+		tallyRefresh:       opts.TallyRefreshInterval,
+	}
+	controller.gameServer = &GameServer{
+		c:                   controller,
+		playersRegistrarCli: opts.PlayersRegistrarCli,
+	}
+	controller.gameServerMaster = &GameServerMaster{
+		c:                   controller,
+		playersRegistrarCli: opts.PlayersRegistrarCli,
+		slaves:              map[string]gs.GameServerSlaveClient{},
+		slaveLastContact:    map[string]time.Time{},
+		allVoted:            make(chan struct{}, 1),
+		slaveVotes:          map[string]*slaveVotes{},
+		access:              &messages.GameAccess{},
 	}
-	instanceID = opts.InstanceID
-	gameID = opts.GameID
-	masterTLSConfig = opts.MasterTLSConfig
-	controller = &Controller{
-		gameServer: &GameServer{
-			playersRegistrarCli: opts.PlayersRegistrarCli,
-		},
-		gameServerMaster: &GameServerMaster{
-			playersRegistrarCli: opts.PlayersRegistrarCli,
-			slaves:              map[string]gs.GameServerSlaveClient{},
-		},
+	if controller.onStop == nil {
+		controller.onStop = func() {}
+	}
+	if controller.leaseDuration == 0 {
+		controller.leaseDuration = defaultLeaseDuration
+	}
+
+	switch {
+	case controller.leases != nil:
+		if controller.gameStore == nil {
+			return nil, fmt.Errorf("masters can only be elected if they share a game store")
+		}
+		controller.campaignOnce(context.Background())
+		if !controller.isLeader() {
+			log.Printf("standing by, game %s is led by %s", controller.gameID, controller.leaderID())
+		}
+		controller.goWorker(controller.campaign)
+	case controller.gameStore != nil:
+		if err := controller.gameServerMaster.restore(context.Background(), nil); err != nil {
+			return nil, fmt.Errorf("unable to restore game %s: %v", controller.gameID, err)
+		}
 	}
 	return controller, nil
 }
 
+// GameID returns the ID of the game this controller runs.
+func (c *Controller) GameID() string {
+	return c.gameID
+}
+
 // GameServerInstance todo
 func (c *Controller) GameServerInstance() *GameServer {
 	return c.gameServer
@@ -70,9 +151,30 @@ func (c *Controller) GameServerMasterInstance() *GameServerMaster {
 	return c.gameServerMaster
 }
 
-// Close all open connections
+// Close stops all workers, gives up the lease if leading and closes all open connections.
 func (c *Controller) Close() {
+	c.stopWorkers()
+	c.workers.Wait()
+	c.releaseLease()
+	c.gameServerMaster.mux.Lock()
+	defer c.gameServerMaster.mux.Unlock()
 	for _, conn := range c.slaveConns {
 		conn.Close()
 	}
 }
+
+// goWorker runs the worker until stop is closed.
+func (c *Controller) goWorker(worker func(stop <-chan struct{})) {
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		worker(c.stop)
+	}()
+}
+
+// stopWorkers stops the round runner, tally merger, slave monitor and campaign, if running.
+func (c *Controller) stopWorkers() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
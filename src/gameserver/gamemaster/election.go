@@ -0,0 +1,147 @@
+package gamemaster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+)
+
+// defaultLeaseDuration is how long a leading master's lease lasts unless renewed.
+const defaultLeaseDuration = 10 * time.Second
+
+// campaign tries to acquire or renew the game's lease every third of the lease duration. Returns once stop is closed.
+// See campaignOnce for what happens each time.
+func (c *Controller) campaign(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.campaignOnce(context.Background())
+	}
+}
+
+// campaignOnce tries to acquire or renew the game's lease once. A standby refreshes its warm copy of the game
+// and takes over once it acquires the lease. A leader that finds the lease taken, or cannot renew it before it
+// expires, steps down so two masters never run the game at once.
+func (c *Controller) campaignOnce(ctx context.Context) {
+	c.leaseMux.Lock()
+	leading, expire := c.leading, c.leaseExpire
+	c.leaseMux.Unlock()
+
+	lease, err := c.leases.AcquireLease(c.gameID, &messages.GameLease{
+		HolderId:   c.instanceID,
+		Address:    c.address,
+		ExpireTime: time.Now().Add(c.leaseDuration).UnixNano(),
+	})
+	if err != nil {
+		log.Printf("unable to acquire lease: %v", err)
+		if leading && time.Now().After(expire) {
+			c.stepDown("lease expired before it could be renewed")
+		}
+		return
+	}
+
+	held := lease.GetHolderId() == c.instanceID
+	c.leaseMux.Lock()
+	c.leader = lease
+	if held {
+		c.leaseExpire = time.Unix(0, lease.GetExpireTime())
+	}
+	c.leaseMux.Unlock()
+
+	switch {
+	case held && !leading:
+		c.takeOver(ctx)
+	case !held && leading:
+		c.stepDown("lease taken by " + lease.GetHolderId())
+	case !held:
+		c.refreshWarmCopy()
+	}
+}
+
+// takeOver restores the game from the store, or from the warm copy if it cannot be loaded, and then makes this
+// master the leader. Slaves are refused until the game is restored.
+func (c *Controller) takeOver(ctx context.Context) {
+	c.leaseMux.Lock()
+	warm := c.warm
+	c.warm = nil
+	c.leaseMux.Unlock()
+
+	log.Printf("acquired lease, taking over game %s", c.gameID)
+	if err := c.gameServerMaster.restore(ctx, warm); err != nil {
+		log.Printf("unable to restore game: %v", err)
+		c.stepDown("unable to restore game")
+		return
+	}
+	c.leaseMux.Lock()
+	c.leading = true
+	c.leaseMux.Unlock()
+	// Compact the previous leader's log.
+	c.gameServerMaster.saveSnapshot(ctx)
+}
+
+// stepDown stops this master from running the game and shuts it down. It must restart to become a standby again.
+func (c *Controller) stepDown(reason string) {
+	log.Printf("stepping down as leader: %s", reason)
+	c.leaseMux.Lock()
+	c.leading = false
+	c.leaseMux.Unlock()
+	c.stopWorkers()
+	go c.onStop()
+}
+
+// refreshWarmCopy replaces this standby's copy of the game with the latest saved by the leader.
+func (c *Controller) refreshWarmCopy() {
+	snapshot, err := c.gameStore.Load(c.gameID)
+	if err != nil {
+		log.Printf("unable to refresh warm copy of game: %v", err)
+		return
+	}
+	c.leaseMux.Lock()
+	defer c.leaseMux.Unlock()
+	if snapshot != nil {
+		c.warm = snapshot
+	}
+}
+
+// releaseLease gives up the lease, if leading, so a standby takes over without waiting for it to expire.
+func (c *Controller) releaseLease() {
+	if c.leases == nil {
+		return
+	}
+	c.leaseMux.Lock()
+	leading := c.leading
+	c.leading = false
+	c.leaseMux.Unlock()
+	if !leading {
+		return
+	}
+	if err := c.leases.ReleaseLease(c.gameID, c.instanceID); err != nil {
+		log.Printf("unable to release lease: %v", err)
+	}
+}
+
+// isLeader returns whether this master is running the game. Always true if masters are not elected.
+// A leader whose lease expired is no longer leading even before its campaign notices, as a standby may already have
+// taken over, so it is checked before every write to the game store.
+func (c *Controller) isLeader() bool {
+	if c.leases == nil {
+		return true
+	}
+	c.leaseMux.Lock()
+	defer c.leaseMux.Unlock()
+	return c.leading && time.Now().Before(c.leaseExpire)
+}
+
+// leaderID returns the instance ID of the leading master, or empty if unknown.
+func (c *Controller) leaderID() string {
+	c.leaseMux.Lock()
+	defer c.leaseMux.Unlock()
+	return c.leader.GetHolderId()
+}
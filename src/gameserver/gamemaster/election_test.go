@@ -0,0 +1,257 @@
+package gamemaster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/gameserver/store"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+const (
+	testGameID        = "88888888-4444-2222-1111-000000000000"
+	testLeaseDuration = 300 * time.Millisecond
+)
+
+func TestFailoverMidRound(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFileStore(t)
+
+	leader := newTestMaster(t, "m1", fs)
+	if !leader.isLeader() {
+		t.Fatal("first master did not acquire the free lease")
+	}
+	if _, err := leader.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, leader, map[string]bool{"w1": true, "b1": false})
+	postTestVote(t, leader, "w1", 1, "e4")
+	if err := leader.GameServerMasterInstance().closeRound(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Kill the leader part way through round 2, once its vote logger logged b1's vote.
+	postTestVote(t, leader, "b1", 2, "e5")
+	leader.GameServerMasterInstance().flushVotes(ctx)
+	crash(leader)
+
+	standby := newTestMaster(t, "m2", fs)
+	if standby.isLeader() {
+		t.Fatal("standby acquired the lease before the leader's expired")
+	}
+	res, err := standby.GameServerInstance().Status(ctx, &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetRole() != pb.StatusResponse_STANDBY || res.GetMasterId() != "m1" {
+		t.Errorf("got role %v led by %q; want STANDBY led by m1", res.GetRole(), res.GetMasterId())
+	}
+	if _, err := standby.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); status.Code(err) != codes.Unavailable {
+		t.Errorf("initializing standby got error %v; want Unavailable", err)
+	}
+
+	deadline := time.Now().Add(10 * testLeaseDuration)
+	for !standby.isLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("standby did not take over once the leader's lease expired")
+		}
+		time.Sleep(testLeaseDuration / 10)
+	}
+	if round := testState(t, standby).GetRoundIndex(); round != 2 {
+		t.Fatalf("new leader is on round %d; want round 2 the old leader was killed in", round)
+	}
+
+	// Round 2 closes with b1's vote cast on the old leader before it was killed.
+	if err := standby.GameServerMasterInstance().closeRound(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if round := testState(t, standby).GetRoundIndex(); round != 3 {
+		t.Errorf("got round %d after closing round 2; want 3", round)
+	}
+	historyRes, err := standby.gameImplementation.History(ctx, &pb.HistoryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds := historyRes.GetHistory().GetChessHistory().GetStateHistory()
+	if len(rounds) != 2 || rounds[0].GetRoundIndex() != 1 || rounds[1].GetRoundIndex() != 2 {
+		t.Fatalf("got history of %d rounds; want rounds 1 and 2", len(rounds))
+	}
+	if m := rounds[1].GetResult().GetMove(); m != "e5" {
+		t.Errorf("got move %q applied in round 2; want e5", m)
+	}
+
+	standby.Close()
+	lease, err := fs.AcquireLease(testGameID, &messages.GameLease{HolderId: "m3", ExpireTime: time.Now().Add(time.Minute).UnixNano()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.GetHolderId() != "m3" {
+		t.Errorf("got lease held by %q after closing the leader; want it released", lease.GetHolderId())
+	}
+}
+
+func TestExpiredLeaderStopsWriting(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFileStore(t)
+
+	leader := newTestMaster(t, "m1", fs)
+	if _, err := leader.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); err != nil {
+		t.Fatal(err)
+	}
+	// Pause the leader past its lease, its campaign never gets to notice.
+	crash(leader)
+	time.Sleep(testLeaseDuration + testLeaseDuration/2)
+	if leader.isLeader() {
+		t.Fatal("leader still leading once its lease expired")
+	}
+
+	saved, err := fs.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, leader, map[string]bool{"w1": true})
+	got, err := fs.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetGame().GetState().GetVersion(); v != saved.GetGame().GetState().GetVersion() {
+		t.Errorf("got saved state version %d after the lease expired; want %d", v, saved.GetGame().GetState().GetVersion())
+	}
+}
+
+// newTestMaster starts a master electing its leader with others sharing fs, as a new process would.
+func newTestMaster(t *testing.T, id string, fs *store.FileStore) *Controller {
+	t.Helper()
+	c, err := NewGameMasterController(Opts{
+		InstanceID:    id,
+		GameID:        testGameID,
+		Store:         fs,
+		Leases:        fs,
+		LeaseDuration: testLeaseDuration,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.stopWorkers()
+		c.workers.Wait()
+	})
+	return c
+}
+
+// crash stops the master without releasing its lease, as if its process was killed.
+func crash(c *Controller) {
+	c.stopWorkers()
+	c.workers.Wait()
+}
+
+func newTestFileStore(t *testing.T) *store.FileStore {
+	dir, err := ioutil.TempDir("", "gamemaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fs, err := store.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func addTestPlayers(t *testing.T, c *Controller, playerToTeam map[string]bool) {
+	t.Helper()
+	ctx := context.Background()
+	for id, white := range playerToTeam {
+		res, err := c.gameImplementation.AddPlayers(ctx, &pb.AddPlayersRequest{
+			Players: []*pb.AddPlayersRequest_NewPlayer{
+				&pb.AddPlayersRequest_NewPlayer{
+					PlayerId: id,
+					Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+						Fields: &messages.Game_NewPlayerFields{
+							Game: &messages.Game_NewPlayerFields_ChessFields{
+								ChessFields: &games.ChessNewPlayerFields{WhiteTeam: white},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.GameServerMasterInstance().membershipChanged(ctx, "", res.GetState())
+	}
+}
+
+func postTestVote(t *testing.T, c *Controller, playerID string, round int32, move string) {
+	t.Helper()
+	if _, err := c.gameImplementation.PostVote(context.Background(), &pb.PostVoteRequest{
+		Vote: &messages.Vote{
+			PlayerId: playerID,
+			GameVote: &messages.Vote_ChessVote{
+				ChessVote: &games.ChessVote{RoundIndex: round, Move: move, PositionHash: testPositionHash(t, c.gameImplementation)},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testState(t *testing.T, c *Controller) *games.ChessState {
+	t.Helper()
+	res, err := c.gameImplementation.State(context.Background(), &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.GetState().GetChessState()
+}
+
+// testGame returns a chess game whose rounds only close when the test closes them.
+func testGame() *messages.Game {
+	now := time.Now()
+	return &messages.Game{
+		Type:      messages.Game_CHESS,
+		Id:        testGameID,
+		StartTime: now.UnixNano(),
+		Metadata: &messages.Game_Metadata{
+			Title: "testTitle",
+			Rules: &messages.Game_Metadata_Rules{
+				VoteApplication: &messages.Game_Metadata_Rules_VoteAppliedAfterTally_{
+					VoteAppliedAfterTally: &messages.Game_Metadata_Rules_VoteAppliedAfterTally{
+						TimeoutSeconds:  3600,
+						WaitFullTimeout: true,
+					},
+				},
+				GameSpecific: &messages.Game_Metadata_Rules_ChessRules{
+					ChessRules: &games.ChessRules{
+						BalanceEnforcement: &games.ChessRules_TolerateDifference{
+							TolerateDifference: 10,
+						},
+					},
+				},
+			},
+		},
+		State: &messages.Game_State{
+			Game: &messages.Game_State_ChessState{
+				ChessState: &games.ChessState{
+					BoardFen:       "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+					RoundIndex:     1,
+					RoundStartTime: now.UnixNano(),
+					RoundEndTime:   now.Add(time.Hour).UnixNano(),
+					Details: &games.ChessState_Details{
+						PlayerIdToTeam: map[string]bool{},
+						PlayerToMove:   map[string]string{},
+					},
+				},
+			},
+		},
+	}
+}
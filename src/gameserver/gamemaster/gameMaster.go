@@ -5,10 +5,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/sambdavidson/community-chess/src/gameserver/game"
+	"github.com/sambdavidson/community-chess/src/gameserver/registry"
 	"github.com/sambdavidson/community-chess/src/proto/messages"
 
 	"github.com/sambdavidson/community-chess/src/lib/auth"
@@ -25,55 +27,125 @@ import (
 
 // GameServerMaster implements the GameServerMaster service.
 type GameServerMaster struct {
+	// c is the controller of the game this master runs.
+	c                   *Controller
 	mux                 sync.Mutex
 	playersRegistrarCli pr.PlayersRegistrarClient
 	slaves              map[string]pb.GameServerSlaveClient
+	// Last time each slave was successfully talked to, in either direction.
+	slaveLastContact map[string]time.Time
+
+	// roundMux is held while a round is being closed.
+	roundMux sync.Mutex
+	// allVoted signals the round runner that every eligible player has voted.
+	allVoted chan struct{}
+
+	// tallyMux is held while the live tally is merged and guards slaveVotes.
+	tallyMux sync.Mutex
+	// Slave ID to its votes of the current round, kept for merging the live tally.
+	slaveVotes map[string]*slaveVotes
+
+	// storeMux is held while the game is written to the game store.
+	storeMux sync.Mutex
+	// Number of closed rounds already in the game store.
+	loggedRounds int
+	// Number of entries appended to the game store's log since the last snapshot.
+	entriesSinceSnapshot int
+	// GetVotes cursor of the latest votes of this master in the game store, see logVotes.
+	voteCursor int64
+
+	// accessMux guards access.
+	accessMux sync.Mutex
+	// Who may join the game when it is invite only.
+	access *messages.GameAccess
 }
 
 // Initialize initializes this server to run the game defined in InitializeRequest.
 func (s *GameServerMaster) Initialize(ctx context.Context, in *pb.InitializeRequest) (*pb.InitializeResponse, error) {
-	if gameImplementation == game.Noop {
-		var ok bool
-		if gameImplementation, ok = game.ImplementationMap[in.GetGame().GetType()]; !ok {
-			return nil, status.Errorf(codes.InvalidArgument, "unknown game type: %v", in.GetGame().GetType())
+	if !s.c.isLeader() {
+		return nil, status.Errorf(codes.Unavailable, "this master is a standby, the game is led by %s", s.c.leaderID())
+	}
+	return s.initialize(ctx, in, nil)
+}
+
+// initialize sets up the game implementation to run the game and starts the round runner and slave monitor.
+// restored is the game saved by a previous run of this master, or nil for a new game.
+func (s *GameServerMaster) initialize(ctx context.Context, in *pb.InitializeRequest, restored *messages.GameSnapshot) (*pb.InitializeResponse, error) {
+	if s.c.gameImplementation != game.Noop {
+		return nil, status.Error(codes.FailedPrecondition, "this master is already initialized")
+	}
+	impl, ok := game.NewImplementation(in.GetGame().GetType())
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown game type: %v", in.GetGame().GetType())
+	}
+	res, err := impl.Initialize(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.c.initializeTime = time.Now()
+	if restored != nil {
+		if err := impl.RestoreSecret(restored.GetSecret()); err != nil {
+			return nil, err
 		}
-		gameType = in.GetGame().GetType()
+		s.c.initializeTime = time.Unix(0, in.GetGame().GetStartTime())
+	}
+	s.c.gameImplementation = impl
+	s.c.gameType = in.GetGame().GetType()
+	if restored != nil {
+		s.setGameAccess(restored.GetAccess())
 	} else {
-		return nil, status.Error(codes.FailedPrecondition, "this master is already initialized")
+		s.setGameAccess(in.GetAccess())
+	}
+	s.saveSnapshot(ctx)
+
+	s.c.goWorker(s.monitorSlaves)
+	if in.GetGame().GetMetadata().GetRules().GetVoteAppliedAfterTally() != nil {
+		s.c.goWorker(s.runRounds)
+		if s.c.tallyRefresh > 0 {
+			s.c.goWorker(s.mergeTallies)
+		}
+		if s.c.gameStore != nil {
+			s.c.goWorker(s.logVotes)
+		}
 	}
-	initializeTime = time.Now()
-	return gameImplementation.Initialize(ctx, in)
+	return res, nil
 }
 
 // AddSlave is called by a GameServerSlave to request to be accepted as a valid slave for this game.
+// A slave that is already added, e.g. after it lost contact with this master, is re-added with a new connection.
 func (s *GameServerMaster) AddSlave(ctx context.Context, in *pb.AddSlaveRequest) (*pb.AddSlaveResponse, error) {
-	slaveID, err := validateSlave(ctx)
+	slaveID, err := validateSlave(ctx, s.c.gameID)
 	if err != nil {
 		return nil, err
 	}
-	if gameImplementation == game.Noop {
+	if !s.c.isLeader() {
+		return nil, status.Errorf(codes.Unavailable, "this master is a standby, the game is led by %s", s.c.leaderID())
+	}
+	if s.c.gameImplementation == game.Noop {
 		return nil, status.Errorf(codes.FailedPrecondition, "master has not yet been initialized")
 	}
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	_, ok := s.slaves[slaveID]
-	if ok {
-		return nil, status.Errorf(codes.FailedPrecondition, "slave %s already added to this master", slaveID)
-	}
-	slaveConn, err := grpc.Dial(in.GetReturnAddress(), grpc.WithTransportCredentials(credentials.NewTLS(masterTLSConfig)))
+	opts := append(registry.WithGameID(s.c.gameID), grpc.WithTransportCredentials(credentials.NewTLS(s.c.masterTLSConfig)))
+	slaveConn, err := grpc.Dial(in.GetReturnAddress(), opts...)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "unable to dial return address")
 	}
+	if oldConn, ok := s.c.slaveConns[slaveID]; ok {
+		log.Printf("re-adding slave %s", slaveID)
+		oldConn.Close()
+	}
 	s.slaves[slaveID] = pb.NewGameServerSlaveClient(slaveConn)
-	controller.slaveConns = append(controller.slaveConns, slaveConn)
+	s.slaveLastContact[slaveID] = time.Now()
+	s.c.slaveConns[slaveID] = slaveConn
 
-	res, err := controller.GameServerInstance().Game(ctx, &pb.GameRequest{Detailed: true})
+	res, err := s.c.gameServer.Game(ctx, &pb.GameRequest{Detailed: true})
 	if err != nil {
 		return nil, err
 	}
 	return &pb.AddSlaveResponse{
-		MasterId: instanceID,
+		MasterId: s.c.instanceID,
 		Game:     res.GetGame(),
 	}, nil
 }
@@ -81,56 +153,279 @@ func (s *GameServerMaster) AddSlave(ctx context.Context, in *pb.AddSlaveRequest)
 // AddPlayers is called by a GameServerSlave to request 1+ player(s) be added to this game.
 func (s *GameServerMaster) AddPlayers(ctx context.Context, in *pb.AddPlayersRequest) (*pb.AddPlayersResponse, error) {
 	log.Println("AddPlayers", in)
-	slaveID, err := validateSlave(ctx)
+	slaveID, err := s.registeredSlave(ctx)
 	if err != nil {
 		return nil, err
 	}
-	res, err := gameImplementation.AddPlayers(ctx, in)
-	if err == nil {
-		s.otherSlavesUpdateState(slaveID, res.GetState())
-	}
-
-	return res, err
+	return s.addPlayers(ctx, slaveID, in)
 }
 
 // RemovePlayers is called by a GameServerSlave to request 1+ player(s) be removed from this game.
 func (s *GameServerMaster) RemovePlayers(ctx context.Context, in *pb.RemovePlayersRequest) (*pb.RemovePlayersResponse, error) {
-	slaveID, err := validateSlave(ctx)
+	slaveID, err := s.registeredSlave(ctx)
 	if err != nil {
 		return nil, err
 	}
-	res, err := gameImplementation.RemovePlayers(ctx, in)
-	if err == nil {
-		s.otherSlavesUpdateState(slaveID, res.GetState())
+	res, err := s.c.gameImplementation.RemovePlayers(ctx, in)
+	if err != nil {
+		return nil, err
 	}
+	s.membershipChanged(ctx, slaveID, res.GetState())
+	s.checkAllVoted(ctx)
 	return res, nil
 }
 
-// StopGame is called by an authorized user and shuts down this game.
+// SwitchPlayerTeam is called by a GameServerSlave to move a player to another team.
+func (s *GameServerMaster) SwitchPlayerTeam(ctx context.Context, in *pb.SwitchPlayerTeamRequest) (*pb.SwitchPlayerTeamResponse, error) {
+	slaveID, err := s.registeredSlave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.switchPlayerTeam(ctx, slaveID, in)
+}
+
+// StopGame is called by an admin and shuts down this game. The game is recorded as aborted,
+// every slave is sent the final state and stopped, and finally this master shuts down.
 func (s *GameServerMaster) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "todo")
+	if err := validateStopper(ctx); err != nil {
+		return nil, err
+	}
+	s.roundMux.Lock()
+	defer s.roundMux.Unlock()
+
+	res, err := s.c.gameImplementation.StopGame(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.c.stopWorkers()
+	log.Printf("game stopped: %q", in.GetReason())
+	s.persist(ctx)
+
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		return nil, err
+	}
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		return nil, err
+	}
+	s.slavesUpdateState("", &pb.UpdateStateRequest{
+		State:   stateRes.GetState(),
+		History: historyRes.GetHistory(),
+	})
+	for id, slaveCli := range s.slaveClients() {
+		cctx, cancel := context.WithTimeout(ctx, slaveCallTimeout)
+		if _, err := slaveCli.StopGame(cctx, in); err != nil {
+			log.Printf("unable to stop slave %s: %v", id, err)
+		}
+		cancel()
+	}
+
+	// Shutting down gracefully waits for this RPC to finish.
+	go s.c.onStop()
+	return res, nil
+}
+
+// ApplyVote is called by a GameServerSlave to apply a vote when votes are applied immediately.
+func (s *GameServerMaster) ApplyVote(ctx context.Context, in *pb.ApplyVoteRequest) (*pb.ApplyVoteResponse, error) {
+	if _, err := s.registeredSlave(ctx); err != nil {
+		return nil, err
+	}
+	return s.applyVote(ctx, in)
+}
+
+// ReportVoters is called by a GameServerSlave to report the players that voted on it this round.
+func (s *GameServerMaster) ReportVoters(ctx context.Context, in *pb.ReportVotersRequest) (*pb.ReportVotersResponse, error) {
+	slaveID, err := s.registeredSlave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in.SlaveId = slaveID
+	res, err := s.c.gameImplementation.ReportVoters(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.checkAllVoted(ctx)
+	return res, nil
+}
+
+// Resync is called by a GameServerSlave that missed a state version and returns the latest state and history.
+func (s *GameServerMaster) Resync(ctx context.Context, in *pb.ResyncRequest) (*pb.ResyncResponse, error) {
+	slaveID, err := s.registeredSlave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Hold the round so the state and history match.
+	s.roundMux.Lock()
+	defer s.roundMux.Unlock()
+
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		return nil, err
+	}
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("resyncing slave %s to state version %d", slaveID, stateRes.GetState().GetVersion())
+	return &pb.ResyncResponse{
+		State:   stateRes.GetState(),
+		History: historyRes.GetHistory(),
+	}, nil
+}
+
+// Heartbeat is called periodically by every GameServerSlave to show it is alive.
+// Returns the current state version so slaves that missed an update can resync.
+func (s *GameServerMaster) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if _, err := s.registeredSlave(ctx); err != nil {
+		return nil, err
+	}
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.HeartbeatResponse{
+		StateVersion: stateRes.GetState().GetVersion(),
+	}, nil
+}
+
+// RemoveSlave is called by a GameServerSlave that is shutting down gracefully.
+func (s *GameServerMaster) RemoveSlave(ctx context.Context, in *pb.RemoveSlaveRequest) (*pb.RemoveSlaveResponse, error) {
+	slaveID, err := s.registeredSlave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.removeSlave(slaveID)
+	log.Printf("removed slave %s", slaveID)
+	return &pb.RemoveSlaveResponse{}, nil
+}
+
+// Status is called by internal services and returns the same status as this master's GameServer.
+func (s *GameServerMaster) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+	return s.c.gameServer.Status(ctx, in)
+}
+
+// addPlayers adds the players if they may join the game and on success pushes the new state to every slave
+// except skipSlave. Players that joined with an invite code are allowed to rejoin without one.
+func (s *GameServerMaster) addPlayers(ctx context.Context, skipSlave string, in *pb.AddPlayersRequest) (*pb.AddPlayersResponse, error) {
+	redeemed, err := s.admit(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.c.gameImplementation.AddPlayers(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.allowPlayers(redeemed)
+	s.membershipChanged(ctx, skipSlave, res.GetState())
+	return res, nil
+}
+
+// switchPlayerTeam moves the player to another team and on success pushes the new state to every slave except skipSlave.
+func (s *GameServerMaster) switchPlayerTeam(ctx context.Context, skipSlave string, in *pb.SwitchPlayerTeamRequest) (*pb.SwitchPlayerTeamResponse, error) {
+	res, err := s.c.gameImplementation.SwitchPlayerTeam(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.membershipChanged(ctx, skipSlave, res.GetState())
+	// The team to move may now have every player voted.
+	s.checkAllVoted(ctx)
+	return res, nil
+}
+
+// applyVote applies the vote and on success pushes the new state and history to every slave.
+func (s *GameServerMaster) applyVote(ctx context.Context, in *pb.ApplyVoteRequest) (*pb.ApplyVoteResponse, error) {
+	s.roundMux.Lock()
+	defer s.roundMux.Unlock()
+
+	res, err := s.c.gameImplementation.ApplyVote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		return nil, err
+	}
+	s.slavesUpdateState("", &pb.UpdateStateRequest{
+		State:   res.GetState(),
+		History: historyRes.GetHistory(),
+	})
+	s.persist(ctx)
+	return res, nil
 }
 
 // otherSlavesUpdateState updates the state of all slaves except skipSlave.
 func (s *GameServerMaster) otherSlavesUpdateState(skipSlave string, state *messages.Game_State) {
+	s.slavesUpdateState(skipSlave, &pb.UpdateStateRequest{
+		State: state,
+	})
+}
+
+// slavesUpdateState sends the UpdateStateRequest to all slaves except skipSlave, unless this master is no longer leading.
+func (s *GameServerMaster) slavesUpdateState(skipSlave string, in *pb.UpdateStateRequest) {
+	if !s.c.isLeader() {
+		return
+	}
 	// TODO: Consider some sort of watcher thread instead.
-	for id, slaveCli := range s.slaves { // TODO: Consider some sort of watcher thread instead.
+	for id, slaveCli := range s.slaveClients() {
 		if id == skipSlave {
 			continue
 		}
 
-		_, err := slaveCli.UpdateState(context.Background(), &pb.UpdateStateRequest{
-			State: state,
-		})
-		if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), slaveCallTimeout)
+		_, err := slaveCli.UpdateState(ctx, in)
+		cancel()
+		if status.Code(err) == codes.Aborted {
+			// The slave already has a newer state, updates may arrive out of order.
+		} else if err != nil {
 			fmt.Println("TODO: DO SOMETHING, unable to update slave state", err)
-
+			continue
 		}
+		s.slaveContacted(id)
+	}
+}
+
+// slaveClients returns a copy of the current slave ID to client map.
+func (s *GameServerMaster) slaveClients() map[string]pb.GameServerSlaveClient {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make(map[string]pb.GameServerSlaveClient, len(s.slaves))
+	for id, cli := range s.slaves {
+		out[id] = cli
 	}
+	return out
 }
 
-// validateSlave returns its unique InstanceID. If anything goes wrong returns a GRPC status error.
-func validateSlave(ctx context.Context) (string, error) {
+// slaveContacted records that the slave was just successfully talked to.
+func (s *GameServerMaster) slaveContacted(slaveID string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.slaves[slaveID]; ok {
+		s.slaveLastContact[slaveID] = time.Now()
+	}
+}
+
+// slaveStatuses returns the status of every slave in instance ID order.
+func (s *GameServerMaster) slaveStatuses() []*pb.StatusResponse_Slave {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make([]*pb.StatusResponse_Slave, 0, len(s.slaves))
+	for id := range s.slaves {
+		out = append(out, &pb.StatusResponse_Slave{
+			InstanceId:      id,
+			LastContactTime: s.slaveLastContact[id].UnixNano(),
+			Healthy:         time.Since(s.slaveLastContact[id]) <= slaveUnhealthyAfter,
+		})
+	}
+	sort.Slice(out, func(a, b int) bool {
+		return out[a].GetInstanceId() < out[b].GetInstanceId()
+	})
+	return out
+}
+
+// validateSlave validates the caller is a slave of the game and returns its unique InstanceID.
+// If anything goes wrong returns a GRPC status error.
+func validateSlave(ctx context.Context, gameID string) (string, error) {
 	x509Cert, err := auth.X509CertificateFromContext(ctx)
 	if err != nil {
 		return "", status.Errorf(codes.Unauthenticated, "could not get x509 from context: %v", err)
@@ -148,6 +443,19 @@ func validateSlave(ctx context.Context) (string, error) {
 	return x509Cert.Subject.CommonName, nil
 }
 
+// validateStopper returns a GRPC status error unless the caller is an admin. Slaves may not stop the game, a single
+// compromised or misbehaving slave must not be able to abort it.
+func validateStopper(ctx context.Context) error {
+	x509Cert, err := auth.X509CertificateFromContext(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "could not get x509 from context: %v", err)
+	}
+	if !contains(x509Cert.DNSNames, tlsconsts.Admin.String()) {
+		return status.Error(codes.PermissionDenied, "only admins may stop the game")
+	}
+	return nil
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -3,6 +3,7 @@ package gamemaster
 import (
 	"context"
 
+	"github.com/sambdavidson/community-chess/src/gameserver/game"
 	"github.com/sambdavidson/community-chess/src/lib/auth/grpcplayertokens"
 
 	"google.golang.org/grpc/codes"
@@ -15,28 +16,30 @@ import (
 
 // GameServer implements the GameServer service.
 type GameServer struct {
+	// c is the controller of the game this server serves.
+	c                   *Controller
 	playersRegistrarCli pr.PlayersRegistrarClient
 }
 
 // Game gets this game.
 func (s *GameServer) Game(ctx context.Context, in *pb.GameRequest) (*pb.GameResponse, error) {
-	metadataRes, err := gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
 	if err != nil {
 		return nil, err
 	}
-	stateRes, err := gameImplementation.State(ctx, &pb.StateRequest{Detailed: in.GetDetailed()})
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{Detailed: in.GetDetailed()})
 	if err != nil {
 		return nil, err
 	}
-	historyRes, err := gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: in.GetDetailed()})
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: in.GetDetailed()})
 	if err != nil {
 		return nil, err
 	}
 	return &pb.GameResponse{
 		Game: &messages.Game{
-			Type:      gameType,
-			Id:        gameID,
-			StartTime: initializeTime.UnixNano(),
+			Type:      s.c.gameType,
+			Id:        s.c.gameID,
+			StartTime: s.c.initializeTime.UnixNano(),
 			Location:  "localhost", // TODO
 			Metadata:  metadataRes.GetMetadata(),
 			State:     stateRes.GetState(),
@@ -47,17 +50,17 @@ func (s *GameServer) Game(ctx context.Context, in *pb.GameRequest) (*pb.GameResp
 
 // Metadata gets this game's metadata.
 func (s *GameServer) Metadata(ctx context.Context, in *pb.MetadataRequest) (*pb.MetadataResponse, error) {
-	return gameImplementation.Metadata(ctx, in)
+	return s.c.gameImplementation.Metadata(ctx, in)
 }
 
 // State gets this game's state.
 func (s *GameServer) State(ctx context.Context, in *pb.StateRequest) (*pb.StateResponse, error) {
-	return gameImplementation.State(ctx, in)
+	return s.c.gameImplementation.State(ctx, in)
 }
 
 // History gets this game's history.
 func (s *GameServer) History(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryResponse, error) {
-	return gameImplementation.History(ctx, in)
+	return s.c.gameImplementation.History(ctx, in)
 }
 
 // Join joins this game.
@@ -66,12 +69,13 @@ func (s *GameServer) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResp
 	if err != nil {
 		return nil, err
 	}
-	_, err = gameImplementation.AddPlayers(ctx, &pb.AddPlayersRequest{
+	res, err := s.c.gameServerMaster.addPlayers(ctx, "", &pb.AddPlayersRequest{
 		Players: []*pb.AddPlayersRequest_NewPlayer{
 			&pb.AddPlayersRequest_NewPlayer{
 				PlayerId: pid,
 				Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
-					Fields: in.Fields,
+					Fields:     in.Fields,
+					InviteCode: in.InviteCode,
 				},
 			},
 		},
@@ -79,7 +83,55 @@ func (s *GameServer) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResp
 	if err != nil {
 		return nil, err
 	}
-	return &pb.JoinResponse{}, nil
+	return &pb.JoinResponse{Fields: res.GetPlayerToFields()[pid]}, nil
+}
+
+// CreateInvite creates an invite code to this game. Only the game's creator may create invites.
+func (s *GameServer) CreateInvite(ctx context.Context, in *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.c.gameServerMaster.updateAccess(ctx, &pb.UpdateAccessRequest{
+		PlayerId: pid,
+		Change:   &pb.UpdateAccessRequest_CreateInvite{CreateInvite: in},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateInviteResponse{Invite: res.GetInvite()}, nil
+}
+
+// RevokeInvite revokes an invite code to this game. Only the game's creator may revoke invites.
+func (s *GameServer) RevokeInvite(ctx context.Context, in *pb.RevokeInviteRequest) (*pb.RevokeInviteResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.c.gameServerMaster.updateAccess(ctx, &pb.UpdateAccessRequest{
+		PlayerId: pid,
+		Change:   &pb.UpdateAccessRequest_RevokeInvite{RevokeInvite: in},
+	}); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeInviteResponse{}, nil
+}
+
+// UpdateAllowList changes the players that may join this game without an invite code.
+// Only the game's creator may change the allow-list.
+func (s *GameServer) UpdateAllowList(ctx context.Context, in *pb.UpdateAllowListRequest) (*pb.UpdateAllowListResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.c.gameServerMaster.updateAccess(ctx, &pb.UpdateAccessRequest{
+		PlayerId: pid,
+		Change:   &pb.UpdateAccessRequest_UpdateAllowList{UpdateAllowList: in},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateAllowListResponse{AllowedPlayerIds: res.GetAccess().GetAllowedPlayerIds()}, nil
 }
 
 // Leave leaves this game.
@@ -88,21 +140,92 @@ func (s *GameServer) Leave(ctx context.Context, in *pb.LeaveRequest) (*pb.LeaveR
 	if err != nil {
 		return nil, err
 	}
-	_, err = gameImplementation.RemovePlayers(ctx, &pb.RemovePlayersRequest{
+	res, err := s.c.gameImplementation.RemovePlayers(ctx, &pb.RemovePlayersRequest{
 		PlayerIds: []string{pid},
 	})
 	if err != nil {
 		return nil, err
 	}
+	s.c.gameServerMaster.membershipChanged(ctx, "", res.GetState())
+	s.c.gameServerMaster.checkAllVoted(ctx)
 	return &pb.LeaveResponse{}, nil
 }
 
-// PostVote posts a vote to this game.
+// SwitchTeam moves the calling player to another team.
+func (s *GameServer) SwitchTeam(ctx context.Context, in *pb.SwitchTeamRequest) (*pb.SwitchTeamResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.c.gameServerMaster.switchPlayerTeam(ctx, "", &pb.SwitchPlayerTeamRequest{
+		PlayerId: pid,
+		Request:  in,
+	}); err != nil {
+		return nil, err
+	}
+	return &pb.SwitchTeamResponse{}, nil
+}
+
+// PostVote posts a vote to this game. When votes are applied immediately the vote is applied by this master.
 func (s *GameServer) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
-	return gameImplementation.PostVote(ctx, in)
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if in.GetVote() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing vote")
+	}
+	in.GetVote().PlayerId = pid
+
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if metadataRes.GetMetadata().GetRules().GetVoteAppliedImmediately() != nil {
+		if _, err := s.c.gameServerMaster.applyVote(ctx, &pb.ApplyVoteRequest{Vote: in.GetVote()}); err != nil {
+			return nil, err
+		}
+		return &pb.PostVoteResponse{}, nil
+	}
+	res, err := s.c.gameImplementation.PostVote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	s.c.gameServerMaster.checkAllVoted(ctx)
+	return res, nil
+}
+
+// RetractVote removes the calling player's vote of the current round from this master.
+func (s *GameServer) RetractVote(ctx context.Context, in *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in.PlayerId = pid
+	return s.c.gameImplementation.RetractVote(ctx, in)
 }
 
-// Status returns the status of this game (and/or the underlying server).
+// Status returns the status of this game and this master, including its connected slaves.
 func (s *GameServer) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "todo")
+	res := &pb.StatusResponse{}
+	if s.c.gameImplementation != game.Noop {
+		var err error
+		if res, err = s.c.gameImplementation.Status(ctx, in); err != nil {
+			return nil, err
+		}
+	}
+	res.InstanceId = s.c.instanceID
+	res.Role = pb.StatusResponse_MASTER
+	if !s.c.isLeader() {
+		res.Role = pb.StatusResponse_STANDBY
+		res.MasterId = s.c.leaderID()
+	}
+	res.Slaves = s.c.gameServerMaster.slaveStatuses()
+	res.SlaveCount = int32(len(res.Slaves))
+	return res, nil
+}
+
+// WatchGame streams every change to this game as seen by this server.
+func (s *GameServer) WatchGame(in *pb.WatchGameRequest, stream pb.GameServer_WatchGameServer) error {
+	return s.c.gameImplementation.WatchGame(in, stream)
 }
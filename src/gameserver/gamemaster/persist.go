@@ -0,0 +1,186 @@
+package gamemaster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/gameserver/game"
+	"github.com/sambdavidson/community-chess/src/gameserver/store"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// snapshotEvery is how many entries are appended to the log before the game is snapshotted again, bounding its length.
+const snapshotEvery = 100
+
+// voteLogInterval is how often the changes to the votes cast on this master are appended to the game store's log.
+const voteLogInterval = time.Second
+
+// restore reloads the game saved by a previous run of this master or by the previous leader, if any.
+// fallback is used if the game cannot be loaded, e.g. a standby's warm copy.
+func (s *GameServerMaster) restore(ctx context.Context, fallback *messages.GameSnapshot) error {
+	snapshot, err := s.c.gameStore.Load(s.c.gameID)
+	if err != nil && fallback == nil {
+		return err
+	}
+	if err != nil || snapshot == nil {
+		snapshot = fallback
+	}
+	if snapshot == nil {
+		return nil
+	}
+	if _, err := s.initialize(ctx, &pb.InitializeRequest{Game: snapshot.GetGame()}, snapshot); err != nil {
+		return err
+	}
+	log.Printf("restored game %s at state version %d", s.c.gameID, snapshot.GetGame().GetState().GetVersion())
+	return nil
+}
+
+// persist appends the changes to the game since it was last persisted to the game store, if there is one.
+// Failures are logged and the game carries on, a restart then loses the changes.
+func (s *GameServerMaster) persist(ctx context.Context) {
+	if s.c.gameStore == nil || s.c.gameImplementation == game.Noop {
+		return
+	}
+	s.storeMux.Lock()
+	defer s.storeMux.Unlock()
+	if !s.c.isLeader() {
+		return
+	}
+	if s.entriesSinceSnapshot >= snapshotEvery {
+		s.saveSnapshotLocked(ctx)
+		return
+	}
+
+	// The vote cursor is read before the state so the state holds at least every vote up to the cursor, and the
+	// state before the history so the history holds at least every round closed in the state.
+	votesRes, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{Cursor: s.voteCursor})
+	if err != nil {
+		log.Printf("unable to persist game: %v", err)
+		return
+	}
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		log.Printf("unable to persist game: %v", err)
+		return
+	}
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		log.Printf("unable to persist game: %v", err)
+		return
+	}
+	if err := s.c.gameStore.Append(s.c.gameID, &messages.GameLogEntry{
+		Time:    time.Now().UnixNano(),
+		State:   stateRes.GetState(),
+		History: store.HistoryAfter(historyRes.GetHistory(), s.loggedRounds),
+		Secret:  s.c.gameImplementation.Secret(),
+		Access:  s.gameAccess(),
+		VoteSeq: votesRes.GetCursor(),
+	}); err != nil {
+		log.Printf("unable to persist game: %v", err)
+		return
+	}
+	s.loggedRounds = store.HistoryLen(historyRes.GetHistory())
+	s.voteCursor = votesRes.GetCursor()
+	s.entriesSinceSnapshot++
+}
+
+// logVotes appends the changes to the votes cast on this master to the game store every vote log interval, so they
+// survive a restart or failover without writing the whole game for every vote.
+// Returns once stop is closed or the game has finished.
+func (s *GameServerMaster) logVotes(stop <-chan struct{}) {
+	ticker := time.NewTicker(voteLogInterval)
+	defer ticker.Stop()
+	for !s.c.gameImplementation.Finished() {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.flushVotes(context.Background())
+	}
+}
+
+// flushVotes appends the changes to the votes cast on this master since they were last persisted to the game store,
+// if there is one and they changed.
+func (s *GameServerMaster) flushVotes(ctx context.Context) {
+	if s.c.gameStore == nil || s.c.gameImplementation == game.Noop {
+		return
+	}
+	s.storeMux.Lock()
+	defer s.storeMux.Unlock()
+	if !s.c.isLeader() {
+		return
+	}
+	res, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{Cursor: s.voteCursor})
+	if err != nil {
+		log.Printf("unable to log votes: %v", err)
+		return
+	}
+	if res.GetCursor() == s.voteCursor {
+		return
+	}
+	if err := s.c.gameStore.Append(s.c.gameID, &messages.GameLogEntry{
+		Time: time.Now().UnixNano(),
+		Votes: &messages.VoteLogEntry{
+			RoundIndex:         res.GetRoundIndex(),
+			Seq:                res.GetCursor(),
+			Full:               res.GetFull(),
+			Votes:              res.GetVotes(),
+			RetractedPlayerIds: res.GetRetractedPlayerIds(),
+		},
+	}); err != nil {
+		log.Printf("unable to log votes: %v", err)
+		return
+	}
+	s.voteCursor = res.GetCursor()
+	s.entriesSinceSnapshot++
+}
+
+// saveSnapshot replaces the game saved in the game store, if there is one, with the current game.
+func (s *GameServerMaster) saveSnapshot(ctx context.Context) {
+	if s.c.gameStore == nil || s.c.gameImplementation == game.Noop {
+		return
+	}
+	s.storeMux.Lock()
+	defer s.storeMux.Unlock()
+	if !s.c.isLeader() {
+		return
+	}
+	s.saveSnapshotLocked(ctx)
+}
+
+// saveSnapshotLocked is saveSnapshot for callers already holding storeMux.
+func (s *GameServerMaster) saveSnapshotLocked(ctx context.Context) {
+	// The vote cursor is read before the game so the game holds at least every vote up to the cursor.
+	votesRes, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{Cursor: s.voteCursor})
+	if err != nil {
+		log.Printf("unable to snapshot game: %v", err)
+		return
+	}
+	res, err := s.c.gameServer.Game(ctx, &pb.GameRequest{Detailed: true})
+	if err != nil {
+		log.Printf("unable to snapshot game: %v", err)
+		return
+	}
+	if err := s.c.gameStore.SaveSnapshot(s.c.gameID, &messages.GameSnapshot{
+		Game:    res.GetGame(),
+		Secret:  s.c.gameImplementation.Secret(),
+		Access:  s.gameAccess(),
+		VoteSeq: votesRes.GetCursor(),
+	}); err != nil {
+		log.Printf("unable to snapshot game: %v", err)
+		return
+	}
+	s.loggedRounds = store.HistoryLen(res.GetGame().GetHistory())
+	s.voteCursor = votesRes.GetCursor()
+	s.entriesSinceSnapshot = 0
+}
+
+// membershipChanged pushes the state to every slave except skipSlave and persists the game after players joined or left.
+func (s *GameServerMaster) membershipChanged(ctx context.Context, skipSlave string, state *messages.Game_State) {
+	s.otherSlavesUpdateState(skipSlave, state)
+	s.persist(ctx)
+}
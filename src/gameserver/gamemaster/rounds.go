@@ -0,0 +1,176 @@
+package gamemaster
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+const (
+	// slaveCallTimeout bounds every call made to a slave while closing a round.
+	slaveCallTimeout = 5 * time.Second
+	// closeRoundRetryWait is how long to wait before retrying a round that failed to close.
+	closeRoundRetryWait = time.Second
+)
+
+// runRounds closes every round of votes once its end time has passed, or early once every eligible player has
+// voted if the game does not wait the full timeout. Returns once stop is closed or the game has finished.
+func (s *GameServerMaster) runRounds(stop <-chan struct{}) {
+	for !s.c.gameImplementation.Finished() {
+		timer := time.NewTimer(time.Until(s.c.gameImplementation.RoundEndTime()))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-s.allVoted:
+			timer.Stop()
+			// The signal may be stale if it was sent while the previous round was closing.
+			if !s.c.gameImplementation.AllVoted() {
+				continue
+			}
+		}
+
+		if err := s.closeRound(context.Background()); err != nil {
+			log.Printf("failed to close round: %v", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(closeRoundRetryWait):
+			}
+		}
+	}
+}
+
+// closeRound stops all servers from accepting votes, gathers the votes of this master and all of its slaves,
+// has the game implementation apply them and pushes the resulting state to every slave.
+func (s *GameServerMaster) closeRound(ctx context.Context) error {
+	s.roundMux.Lock()
+	defer s.roundMux.Unlock()
+
+	slaves := s.slaveClients()
+	s.setAcceptingVotes(ctx, slaves, false)
+
+	votes, err := s.collectVotes(ctx, slaves)
+	if err != nil {
+		s.setAcceptingVotes(ctx, slaves, true)
+		return err
+	}
+	state, err := s.c.gameImplementation.CloseRound(ctx, votes)
+	if err != nil {
+		s.setAcceptingVotes(ctx, slaves, true)
+		return err
+	}
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: true})
+	if err != nil {
+		s.setAcceptingVotes(ctx, slaves, true)
+		return err
+	}
+	s.slavesUpdateState("", &pb.UpdateStateRequest{
+		State:   state,
+		History: historyRes.GetHistory(),
+	})
+	s.persist(ctx)
+	if s.c.gameImplementation.Finished() {
+		log.Println("game finished, no longer accepting votes")
+		return nil
+	}
+	s.setAcceptingVotes(ctx, slaves, true)
+	return nil
+}
+
+// checkAllVoted signals the round runner to close the round early if every eligible player has voted
+// and the game does not wait the full timeout.
+func (s *GameServerMaster) checkAllVoted(ctx context.Context) {
+	res, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		return
+	}
+	tally := res.GetMetadata().GetRules().GetVoteAppliedAfterTally()
+	if tally == nil || tally.GetWaitFullTimeout() || !s.c.gameImplementation.AllVoted() {
+		return
+	}
+	select {
+	case s.allVoted <- struct{}{}:
+	default: // Already signaled.
+	}
+}
+
+// setAcceptingVotes changes whether this master and the passed slaves accept votes. Slave failures are logged.
+func (s *GameServerMaster) setAcceptingVotes(ctx context.Context, slaves map[string]pb.GameServerSlaveClient, accepting bool) {
+	req := &pb.ChangeAcceptingVotesRequest{AcceptingVotes: accepting}
+	if _, err := s.c.gameImplementation.ChangeAcceptingVotes(ctx, req); err != nil {
+		log.Printf("unable to change master accepting votes to %v: %v", accepting, err)
+	}
+	for id, slaveCli := range slaves {
+		cctx, cancel := context.WithTimeout(ctx, slaveCallTimeout)
+		if _, err := slaveCli.ChangeAcceptingVotes(cctx, req); err != nil {
+			log.Printf("unable to change slave %s accepting votes to %v: %v", id, accepting, err)
+		} else {
+			s.slaveContacted(id)
+		}
+		cancel()
+	}
+}
+
+// collectVotes returns the votes of this master followed by those of each slave in slave ID order.
+// Votes from a slave that cannot be reached or is on another round are dropped. If votes are aggregated each slave's
+// tally is matched back to the players that voted.
+func (s *GameServerMaster) collectVotes(ctx context.Context, slaves map[string]pb.GameServerSlaveClient) ([]*messages.Vote, error) {
+	own, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	sets := [][]*messages.Vote{own.GetVotes()}
+
+	ids := make([]string, 0, len(slaves))
+	for id := range slaves {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		cctx, cancel := context.WithTimeout(ctx, slaveCallTimeout)
+		res, err := slaves[id].GetVotes(cctx, &pb.GetVotesRequest{Aggregated: s.c.aggregateVotes})
+		cancel()
+		if err != nil {
+			log.Printf("unable to get votes from slave %s: %v", id, err)
+			continue
+		}
+		s.slaveContacted(id)
+		if res.GetRoundIndex() != own.GetRoundIndex() {
+			log.Printf("slave %s returned votes for round %d; current round %d", id, res.GetRoundIndex(), own.GetRoundIndex())
+			continue
+		}
+		votes := res.GetVotes()
+		if res.GetTally() != nil {
+			votes, err = s.c.gameImplementation.VotesFromTally(res.GetRoundIndex(), res.GetTally())
+			if err != nil {
+				log.Printf("slave %s returned a bad tally: %v", id, err)
+				continue
+			}
+		}
+		sets = append(sets, votes)
+	}
+	return mergeVotes(sets...), nil
+}
+
+// mergeVotes joins sets of votes keeping only the first vote of each player.
+func mergeVotes(sets ...[]*messages.Vote) []*messages.Vote {
+	seen := map[string]bool{}
+	out := []*messages.Vote{}
+	for _, set := range sets {
+		for _, v := range set {
+			if seen[v.GetPlayerId()] {
+				log.Printf("player %s voted on more than one server, keeping its first vote", v.GetPlayerId())
+				continue
+			}
+			seen[v.GetPlayerId()] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
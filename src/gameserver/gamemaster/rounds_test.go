@@ -0,0 +1,146 @@
+package gamemaster
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/sambdavidson/community-chess/src/gameserver/game"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// testSlave is a slave client running the game implementation in process.
+type testSlave struct {
+	pb.GameServerSlaveClient
+	impl game.Implementation
+}
+
+func (s *testSlave) GetVotes(ctx context.Context, in *pb.GetVotesRequest, opts ...grpc.CallOption) (*pb.GetVotesResponse, error) {
+	return s.impl.GetVotes(ctx, in)
+}
+
+func (s *testSlave) UpdateTally(ctx context.Context, in *pb.UpdateTallyRequest, opts ...grpc.CallOption) (*pb.UpdateTallyResponse, error) {
+	return s.impl.UpdateTally(ctx, in)
+}
+
+func TestCollectVotesAggregated(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMaster(t, "m1", newTestFileStore(t))
+	if _, err := c.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); err != nil {
+		t.Fatal(err)
+	}
+	players := map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false}
+	addTestPlayers(t, c, players)
+
+	slaves := map[string]pb.GameServerSlaveClient{
+		"s1": newTestSlave(t, map[string]string{"w1": "e4", "w2": "d4"}),
+		// w2 voted on both slaves, only its vote on s1 counts.
+		"s2": newTestSlave(t, map[string]string{"w2": "e4", "w3": "e4", "late": "Nf3"}),
+	}
+
+	tallies := map[bool]string{}
+	for _, aggregate := range []bool{false, true} {
+		c.aggregateVotes = aggregate
+		votes, err := c.GameServerMasterInstance().collectVotes(ctx, slaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		playerToMove := []string{}
+		for _, v := range votes {
+			if _, joined := players[v.GetPlayerId()]; joined {
+				playerToMove = append(playerToMove, v.GetPlayerId()+"="+v.GetChessVote().GetMove())
+			}
+		}
+		sort.Strings(playerToMove)
+		tallies[aggregate] = fmt.Sprint(playerToMove)
+	}
+	if want := "[w1=e4 w2=d4 w3=e4]"; tallies[false] != want || tallies[true] != want {
+		t.Errorf("got votes %s per vote and %s aggregated; want both %s", tallies[false], tallies[true], want)
+	}
+
+	state, err := c.gameImplementation.CloseRound(ctx, mustCollectVotes(t, c, slaves))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.GetChessState().GetRoundIndex() != 2 {
+		t.Errorf("got round %d after closing the aggregated tally; want 2", state.GetChessState().GetRoundIndex())
+	}
+	historyRes, err := c.gameImplementation.History(ctx, &pb.HistoryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := historyRes.GetHistory().GetChessHistory().GetStateHistory()[0]
+	if counts := closed.GetMoveToCount(); len(counts) != 2 || counts["e4"] != 2 || counts["d4"] != 1 {
+		t.Errorf("got closed round tally %v; want e4 twice and d4 once", counts)
+	}
+}
+
+func mustCollectVotes(t *testing.T, c *Controller, slaves map[string]pb.GameServerSlaveClient) []*messages.Vote {
+	t.Helper()
+	votes, err := c.GameServerMasterInstance().collectVotes(context.Background(), slaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return votes
+}
+
+// newTestSlave returns a slave of testGame accepting votes, with w1, w2, w3, b1 and late joined, which received the votes.
+// late's join never reached the master.
+func newTestSlave(t *testing.T, playerToMove map[string]string) *testSlave {
+	t.Helper()
+	ctx := context.Background()
+	impl, _ := game.NewImplementation(messages.Game_CHESS)
+	if _, err := impl.Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := impl.ChangeAcceptingVotes(ctx, &pb.ChangeAcceptingVotesRequest{AcceptingVotes: true}); err != nil {
+		t.Fatal(err)
+	}
+	req := &pb.AddPlayersRequest{}
+	for p, white := range map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false, "late": true} {
+		req.Players = append(req.Players, &pb.AddPlayersRequest_NewPlayer{
+			PlayerId: p,
+			Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
+				Fields: &messages.Game_NewPlayerFields{
+					Game: &messages.Game_NewPlayerFields_ChessFields{
+						ChessFields: &games.ChessNewPlayerFields{WhiteTeam: white},
+					},
+				},
+			},
+		})
+	}
+	if _, err := impl.AddPlayers(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	for p, move := range playerToMove {
+		postSlaveVote(t, impl, p, move)
+	}
+	return &testSlave{impl: impl}
+}
+
+func postSlaveVote(t *testing.T, impl game.Implementation, playerID, move string) {
+	t.Helper()
+	if _, err := impl.PostVote(context.Background(), &pb.PostVoteRequest{Vote: &messages.Vote{
+		PlayerId: playerID,
+		GameVote: &messages.Vote_ChessVote{ChessVote: &games.ChessVote{RoundIndex: 1, Move: move, PositionHash: testPositionHash(t, impl)}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPositionHash returns the hash of the game's current board FEN that votes must be cast with.
+func testPositionHash(t *testing.T, impl game.Implementation) []byte {
+	t.Helper()
+	res, err := impl.State(context.Background(), &pb.StateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(res.GetState().GetChessState().GetBoardFen()))
+	return sum[:]
+}
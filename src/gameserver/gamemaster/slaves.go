@@ -0,0 +1,97 @@
+package gamemaster
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// slaveCheckInterval is how often the health of every slave is checked.
+	slaveCheckInterval = 5 * time.Second
+	// slaveUnhealthyAfter is how long a slave may go without contact before it is reported unhealthy.
+	// Slaves send a heartbeat every 5 seconds so this allows a couple to be missed.
+	slaveUnhealthyAfter = 15 * time.Second
+	// slaveEvictAfter is how long a slave may go without contact before it is evicted.
+	slaveEvictAfter = time.Minute
+)
+
+// monitorSlaves reports slaves that have not been heard from as unhealthy and evicts them once they have been
+// silent for too long. Returns once stop is closed.
+func (s *GameServerMaster) monitorSlaves(stop <-chan struct{}) {
+	ticker := time.NewTicker(slaveCheckInterval)
+	defer ticker.Stop()
+	unhealthy := map[string]bool{}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for id, silent := range s.slaveSilences() {
+			switch {
+			case silent > slaveEvictAfter:
+				s.removeSlave(id)
+				delete(unhealthy, id)
+				log.Printf("evicted slave %s, silent for %v", id, silent)
+			case silent > slaveUnhealthyAfter:
+				if !unhealthy[id] {
+					unhealthy[id] = true
+					log.Printf("slave %s is unhealthy, silent for %v", id, silent)
+				}
+			default:
+				delete(unhealthy, id)
+			}
+		}
+	}
+}
+
+// slaveSilences returns how long it has been since each slave was last contacted.
+func (s *GameServerMaster) slaveSilences() map[string]time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	out := make(map[string]time.Duration, len(s.slaves))
+	for id := range s.slaves {
+		out[id] = time.Since(s.slaveLastContact[id])
+	}
+	return out
+}
+
+// removeSlave forgets the slave, closes its connection and drops the players it reported as having voted this round.
+// See RemoveSlave in master.proto for what happens to the slave's votes.
+func (s *GameServerMaster) removeSlave(slaveID string) {
+	s.mux.Lock()
+	_, ok := s.slaves[slaveID]
+	conn := s.c.slaveConns[slaveID]
+	delete(s.slaves, slaveID)
+	delete(s.slaveLastContact, slaveID)
+	delete(s.c.slaveConns, slaveID)
+	s.mux.Unlock()
+
+	if !ok {
+		return
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	s.c.gameImplementation.DropSlaveVoters(slaveID)
+}
+
+// registeredSlave validates the caller is a slave added to this master, records the contact and returns its InstanceID.
+// If anything goes wrong returns a GRPC status error.
+func (s *GameServerMaster) registeredSlave(ctx context.Context) (string, error) {
+	slaveID, err := validateSlave(ctx, s.c.gameID)
+	if err != nil {
+		return "", err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.slaves[slaveID]; !ok {
+		return "", status.Errorf(codes.NotFound, "slave %s is not added to this master", slaveID)
+	}
+	s.slaveLastContact[slaveID] = time.Now()
+	return slaveID, nil
+}
@@ -0,0 +1,132 @@
+package gamemaster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// slaveVotes are the votes a slave received in one round, kept up to date with GetVotes cursors so each merge of
+// the live tally only fetches the votes that changed.
+type slaveVotes struct {
+	roundIndex   int32
+	cursor       int64
+	playerToVote map[string]*messages.Vote
+}
+
+// mergeTallies merges the live tally every tally refresh interval so players see the same counts on every server.
+// Returns once stop is closed or the game has finished.
+func (s *GameServerMaster) mergeTallies(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.c.tallyRefresh)
+	defer ticker.Stop()
+	for !s.c.gameImplementation.Finished() {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := s.mergeTally(context.Background()); err != nil {
+			log.Printf("unable to merge the live tally: %v", err)
+		}
+	}
+}
+
+// mergeTally counts the current round's votes of this master and all of its slaves and sends the counts to every
+// server of the game. Slaves that cannot be reached are counted with the votes they last returned.
+func (s *GameServerMaster) mergeTally(ctx context.Context) error {
+	s.tallyMux.Lock()
+	defer s.tallyMux.Unlock()
+
+	own, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{})
+	if err != nil {
+		return err
+	}
+	sets := [][]*messages.Vote{own.GetVotes()}
+
+	slaves := s.slaveClients()
+	for id := range s.slaveVotes {
+		if _, ok := slaves[id]; !ok {
+			delete(s.slaveVotes, id)
+		}
+	}
+	ids := make([]string, 0, len(slaves))
+	for id := range slaves {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := s.refreshSlaveVotes(ctx, id, slaves[id], own.GetRoundIndex()); err != nil {
+			log.Printf("unable to refresh the votes of slave %s: %v", id, err)
+		}
+		if v, ok := s.slaveVotes[id]; ok && v.roundIndex == own.GetRoundIndex() {
+			set := make([]*messages.Vote, 0, len(v.playerToVote))
+			for _, vote := range v.playerToVote {
+				set = append(set, vote)
+			}
+			sets = append(sets, set)
+		}
+	}
+
+	req := &pb.UpdateTallyRequest{
+		RoundIndex:  own.GetRoundIndex(),
+		MoveToCount: s.c.gameImplementation.CountVotes(mergeVotes(sets...)),
+		TallyTime:   time.Now().UnixNano(),
+	}
+	if _, err := s.c.gameImplementation.UpdateTally(ctx, req); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		cctx, cancel := context.WithTimeout(ctx, slaveCallTimeout)
+		if _, err := slaves[id].UpdateTally(cctx, req); err != nil {
+			log.Printf("unable to update the tally of slave %s: %v", id, err)
+		} else {
+			s.slaveContacted(id)
+		}
+		cancel()
+	}
+	return nil
+}
+
+// refreshSlaveVotes updates the kept votes of the slave with those changed since they were last fetched.
+// The kept votes are dropped if the slave is on another round or returns every vote again.
+// This function must be called while holding tallyMux.
+func (s *GameServerMaster) refreshSlaveVotes(ctx context.Context, slaveID string, slaveCli pb.GameServerSlaveClient, roundIndex int32) error {
+	kept, ok := s.slaveVotes[slaveID]
+	if !ok || kept.roundIndex != roundIndex {
+		kept = &slaveVotes{roundIndex: roundIndex, playerToVote: map[string]*messages.Vote{}}
+		s.slaveVotes[slaveID] = kept
+	}
+	cctx, cancel := context.WithTimeout(ctx, slaveCallTimeout)
+	res, err := slaveCli.GetVotes(cctx, &pb.GetVotesRequest{Aggregated: s.c.aggregateVotes, Cursor: kept.cursor})
+	cancel()
+	if err != nil {
+		return err
+	}
+	s.slaveContacted(slaveID)
+	if res.GetRoundIndex() != roundIndex {
+		delete(s.slaveVotes, slaveID)
+		return fmt.Errorf("slave returned votes for round %d; current round %d", res.GetRoundIndex(), roundIndex)
+	}
+	votes := res.GetVotes()
+	if res.GetTally() != nil {
+		if votes, err = s.c.gameImplementation.VotesFromTally(res.GetRoundIndex(), res.GetTally()); err != nil {
+			return fmt.Errorf("bad tally: %v", err)
+		}
+	}
+	if res.GetFull() {
+		kept.playerToVote = make(map[string]*messages.Vote, len(votes))
+	}
+	for _, p := range res.GetRetractedPlayerIds() {
+		delete(kept.playerToVote, p)
+	}
+	for _, v := range votes {
+		kept.playerToVote[v.GetPlayerId()] = v
+	}
+	kept.cursor = res.GetCursor()
+	return nil
+}
@@ -0,0 +1,75 @@
+package gamemaster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+func TestMergeTally(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMaster(t, "m1", newTestFileStore(t))
+	if _, err := c.GameServerMasterInstance().Initialize(ctx, &pb.InitializeRequest{Game: testGame()}); err != nil {
+		t.Fatal(err)
+	}
+	addTestPlayers(t, c, map[string]bool{"w1": true, "w2": true, "w3": true, "b1": false})
+	s1 := newTestSlave(t, map[string]string{"w1": "e4"})
+	s2 := newTestSlave(t, map[string]string{"w2": "d4", "late": "Nf3"})
+	s := c.GameServerMasterInstance()
+	s.slaves["s1"], s.slaves["s2"] = s1, s2
+
+	tallies := func() map[string]string {
+		t.Helper()
+		out := map[string]string{}
+		for id, impl := range map[string]interface {
+			State(context.Context, *pb.StateRequest) (*pb.StateResponse, error)
+		}{"m1": c.gameImplementation, "s1": s1.impl, "s2": s2.impl} {
+			res, err := impl.State(ctx, &pb.StateRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.GetState().GetChessState().GetTallyTime() == 0 {
+				t.Errorf("got no tally time on %s; want the time the tally was merged", id)
+			}
+			out[id] = fmt.Sprint(res.GetState().GetChessState().GetMoveToCount())
+		}
+		return out
+	}
+
+	if err := s.mergeTally(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// late never joined the master so its vote is not counted.
+	want := "map[d4:1 e4:1]"
+	for id, got := range tallies() {
+		if got != want {
+			t.Errorf("got tally %s on %s; want %s", got, id, want)
+		}
+	}
+	res, err := s1.impl.State(ctx, &pb.StateRequest{Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(res.GetState().GetChessState().GetMoveToCount()); got != "map[e4:1]" {
+		t.Errorf("got detailed tally %s on s1; want only its own votes", got)
+	}
+
+	postSlaveVote(t, s2.impl, "w3", "e4")
+	if _, err := s1.impl.RemovePlayers(ctx, &pb.RemovePlayersRequest{PlayerIds: []string{"w1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.mergeTally(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// w1's vote is retracted on s1 and w3's new vote is fetched from s2, both since the previous merge's cursors.
+	for id, got := range tallies() {
+		if got != want {
+			t.Errorf("got tally %s on %s after w1 left and w3 voted; want %s", got, id, want)
+		}
+	}
+	if s.slaveVotes["s1"].cursor == 0 || len(s.slaveVotes["s1"].playerToVote) != 0 {
+		t.Errorf("got s1 votes %v at cursor %d; want none at the latest cursor", s.slaveVotes["s1"].playerToVote, s.slaveVotes["s1"].cursor)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/sambdavidson/community-chess/src/gameserver/game"
@@ -13,95 +14,115 @@ import (
 	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
 
 	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
 // Opts contains intialization options and variables for a new GameServerSlave.
 type Opts struct {
-	InstanceID          string
-	GameID              string
-	SlaveAddress        string
-	MasterAddress       string
+	InstanceID   string
+	GameID       string
+	SlaveAddress string
+	// MasterAddresses of every master of the game. The slave registers with whichever leads the game.
+	MasterAddresses     []string
 	ServerTLSConfig     *tls.Config
 	SlaveTLSConfig      *tls.Config
 	PlayersRegistrarCli pr.PlayersRegistrarClient
+	// OnStop is called once the game has been stopped so the surrounding process can shut down.
+	OnStop func()
 }
 
-// Controller owns both the GameServer and GameServerSlave and manages their game data.
+// Controller owns both the GameServer and GameServerSlave of one game and manages their game data.
+// A process may run any number of controllers, one per game.
 type Controller struct {
 	server      *GameServer
 	serverSlave *GameServerSlave
 
-	masterCli  gs.GameServerMasterClient
-	masterConn *grpc.ClientConn
-}
-
-var (
 	instanceID         string
 	gameID             string
 	gameType           messages.Game_Type
-	gameImplementation = game.Noop
+	gameImplementation game.Implementation
 	initializeTime     time.Time
-	partialGameProto   *messages.Game
-	controller         *Controller
-	slaveTLSConfig     *tls.Config
-)
 
-// NewGameSlaveController builts a new slave and registers itself to the master.
+	masterCli gs.GameServerMasterClient
+	masters   *masterConns
+	onStop    func()
+
+	// Closed to stop sending heartbeats and voter reports.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGameSlaveController builts a new slave of the game opts.GameID and registers itself to the master.
 func NewGameSlaveController(opts Opts) (*Controller, error) {
 	var err error
-	if controller != nil {
-		return nil, fmt.Errorf("GameSlave Controller already initialized")
-	}
-	instanceID = opts.InstanceID
-	gameID = opts.GameID
-	slaveTLSConfig = opts.SlaveTLSConfig
-
-	masterConn, err := grpc.Dial(opts.MasterAddress, grpc.WithTransportCredentials(credentials.NewTLS(slaveTLSConfig)))
+	masters, err := dialMasters(opts.GameID, opts.MasterAddresses, opts.SlaveTLSConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial master: %v", err)
+		return nil, err
 	}
-	masterCli := gs.NewGameServerMasterClient(masterConn)
+	masterCli := gs.NewGameServerMasterClient(masters)
 
 	log.Printf("Connecting to master and adding self as slave...")
-	res, err := masterCli.AddSlave(context.Background(), &gs.AddSlaveRequest{
-		ReturnAddress: opts.SlaveAddress,
-	})
-	if err != nil {
+	var res *gs.AddSlaveResponse
+	if err := masters.tryEach(func(address string) error {
+		res, err = masterCli.AddSlave(context.Background(), &gs.AddSlaveRequest{
+			ReturnAddress: opts.SlaveAddress,
+		})
+		return err
+	}); err != nil {
+		masters.Close()
 		return nil, fmt.Errorf("failed to add self as slave to master: %v", err)
 	}
 
-	log.Printf("Added self as slave to master: %s!\n%v", opts.MasterAddress, res)
-	controller = &Controller{
-		server: &GameServer{
-			masterCli:           masterCli,
-			playersRegistrarCli: opts.PlayersRegistrarCli,
-		},
-		serverSlave: &GameServerSlave{
-			masterID:            res.GetMasterId(),
-			masterCli:           masterCli,
-			playersRegistrarCli: opts.PlayersRegistrarCli,
-		},
-		masterConn: masterConn,
+	log.Printf("Added self as slave to master: %s!\n%v", masters.currentAddress(), res)
+	controller := &Controller{
+		instanceID: opts.InstanceID,
+		gameID:     opts.GameID,
+		masterCli:  masterCli,
+		masters:    masters,
+		onStop:     opts.OnStop,
+		stop:       make(chan struct{}),
+	}
+	controller.server = &GameServer{
+		c:                   controller,
+		masterCli:           masterCli,
+		playersRegistrarCli: opts.PlayersRegistrarCli,
+	}
+	controller.serverSlave = &GameServerSlave{
+		c:                   controller,
+		masterID:            res.GetMasterId(),
+		returnAddress:       opts.SlaveAddress,
+		masterCli:           masterCli,
+		masters:             masters,
+		playersRegistrarCli: opts.PlayersRegistrarCli,
+	}
+	if controller.onStop == nil {
+		controller.onStop = func() {}
 	}
 
 	var ok bool
-	if gameImplementation, ok = game.ImplementationMap[res.GetGame().GetType()]; !ok {
+	if controller.gameImplementation, ok = game.NewImplementation(res.GetGame().GetType()); !ok {
+		masters.Close()
 		return nil, status.Errorf(codes.InvalidArgument, "unknown game type: %v", res.GetGame().GetType())
 	}
-	if _, err = gameImplementation.Initialize(context.Background(), &gs.InitializeRequest{
+	if _, err = controller.gameImplementation.Initialize(context.Background(), &gs.InitializeRequest{
 		Game: res.GetGame(),
 	}); err != nil {
+		masters.Close()
 		return nil, fmt.Errorf("unable to initialize game implementation: %v", err)
 	}
-	gameType = res.GetGame().GetType()
-	initializeTime = time.Unix(0, res.GetGame().GetStartTime())
+	controller.gameType = res.GetGame().GetType()
+	controller.initializeTime = time.Unix(0, res.GetGame().GetStartTime())
+	go controller.serverSlave.heartbeat(controller.stop)
+	go controller.server.reportVoters(controller.stop)
 	return controller, nil
 }
 
+// GameID returns the ID of the game this controller serves.
+func (c *Controller) GameID() string {
+	return c.gameID
+}
+
 // GameServerInstance todo
 func (c *Controller) GameServerInstance() *GameServer {
 	return c.server
@@ -112,9 +133,15 @@ func (c *Controller) GameServerSlaveInstance() *GameServerSlave {
 	return c.serverSlave
 }
 
-// Close all open connections
+// Close removes this slave from the master and closes all open connections.
 func (c *Controller) Close() {
-	if c.masterConn != nil {
-		c.masterConn.Close()
-	}
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+		defer cancel()
+		if _, err := c.masterCli.RemoveSlave(ctx, &gs.RemoveSlaveRequest{}); err != nil {
+			log.Printf("unable to remove self from master: %v", err)
+		}
+	})
+	c.masters.Close()
 }
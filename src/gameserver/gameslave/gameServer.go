@@ -2,6 +2,8 @@ package gameslave
 
 import (
 	"context"
+	"log"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -13,31 +15,38 @@ import (
 	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
 )
 
+// masterCallTimeout bounds calls made to the master in the background.
+const masterCallTimeout = 5 * time.Second
+
 // GameServer implements the GameServer service.
 type GameServer struct {
+	// c is the controller of the game this server serves.
+	c                   *Controller
 	playersRegistrarCli pr.PlayersRegistrarClient
 	masterCli           pb.GameServerMasterClient
+	// Voters waiting to be reported to the master, see reportVoters.
+	voters voterReports
 }
 
 // Game gets this game.
 func (s *GameServer) Game(ctx context.Context, in *pb.GameRequest) (*pb.GameResponse, error) {
-	metadataRes, err := gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
 	if err != nil {
 		return nil, err
 	}
-	stateRes, err := gameImplementation.State(ctx, &pb.StateRequest{Detailed: in.GetDetailed()})
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{Detailed: in.GetDetailed()})
 	if err != nil {
 		return nil, err
 	}
-	historyRes, err := gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: in.GetDetailed()})
+	historyRes, err := s.c.gameImplementation.History(ctx, &pb.HistoryRequest{Detailed: in.GetDetailed()})
 	if err != nil {
 		return nil, err
 	}
 	return &pb.GameResponse{
 		Game: &messages.Game{
-			Type:      gameType,
-			Id:        gameID,
-			StartTime: initializeTime.UnixNano(),
+			Type:      s.c.gameType,
+			Id:        s.c.gameID,
+			StartTime: s.c.initializeTime.UnixNano(),
 			Location:  "localhost", // TODO
 			Metadata:  metadataRes.GetMetadata(),
 			State:     stateRes.GetState(),
@@ -48,17 +57,17 @@ func (s *GameServer) Game(ctx context.Context, in *pb.GameRequest) (*pb.GameResp
 
 // Metadata gets this game's metadata.
 func (s *GameServer) Metadata(ctx context.Context, in *pb.MetadataRequest) (*pb.MetadataResponse, error) {
-	return gameImplementation.Metadata(ctx, in)
+	return s.c.gameImplementation.Metadata(ctx, in)
 }
 
 // State gets this game's state.
 func (s *GameServer) State(ctx context.Context, in *pb.StateRequest) (*pb.StateResponse, error) {
-	return gameImplementation.State(ctx, in)
+	return s.c.gameImplementation.State(ctx, in)
 }
 
 // History gets this game's history.
 func (s *GameServer) History(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryResponse, error) {
-	return gameImplementation.History(ctx, in)
+	return s.c.gameImplementation.History(ctx, in)
 }
 
 // Join joins this game.
@@ -67,12 +76,13 @@ func (s *GameServer) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResp
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
 	}
-	_, err = s.masterCli.AddPlayers(ctx, &pb.AddPlayersRequest{
+	res, err := s.masterCli.AddPlayers(ctx, &pb.AddPlayersRequest{
 		Players: []*pb.AddPlayersRequest_NewPlayer{
 			&pb.AddPlayersRequest_NewPlayer{
 				PlayerId: pid,
 				Request: &pb.AddPlayersRequest_NewPlayer_JoinRequest{
-					Fields: in.Fields,
+					Fields:     in.Fields,
+					InviteCode: in.InviteCode,
 				},
 			},
 		},
@@ -80,21 +90,174 @@ func (s *GameServer) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResp
 	if err != nil {
 		return nil, err
 	}
-	// TODO: update state and stuff.
-	return &pb.JoinResponse{}, nil
+	// The master updates every other slave, this one applies the state from the response.
+	_, err = s.c.serverSlave.updateState(ctx, &pb.UpdateStateRequest{State: res.GetState()})
+	if err != nil && status.Code(err) != codes.Aborted {
+		log.Printf("unable to apply state after join: %v", err)
+	}
+	return &pb.JoinResponse{Fields: res.GetPlayerToFields()[pid]}, nil
+}
+
+// SwitchTeam asks the master to move the calling player to another team.
+func (s *GameServer) SwitchTeam(ctx context.Context, in *pb.SwitchTeamRequest) (*pb.SwitchTeamResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
+	}
+	// The master may not have been told of a vote on this slave yet.
+	votesRes, err := s.c.gameImplementation.GetVotes(ctx, &pb.GetVotesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range votesRes.GetVotes() {
+		if v.GetPlayerId() == pid {
+			return nil, status.Errorf(codes.FailedPrecondition, "player %s voted this round and cannot switch until round %d closes", pid, votesRes.GetRoundIndex())
+		}
+	}
+	res, err := s.masterCli.SwitchPlayerTeam(ctx, &pb.SwitchPlayerTeamRequest{
+		PlayerId: pid,
+		Request:  in,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The master updates every other slave, this one applies the state from the response.
+	_, err = s.c.serverSlave.updateState(ctx, &pb.UpdateStateRequest{State: res.GetState()})
+	if err != nil && status.Code(err) != codes.Aborted {
+		log.Printf("unable to apply state after switching teams: %v", err)
+	}
+	return &pb.SwitchTeamResponse{}, nil
+}
+
+// CreateInvite asks the master to create an invite code to this game. Only the game's creator may create invites.
+func (s *GameServer) CreateInvite(ctx context.Context, in *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	res, err := s.updateAccess(ctx, &pb.UpdateAccessRequest{
+		Change: &pb.UpdateAccessRequest_CreateInvite{CreateInvite: in},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateInviteResponse{Invite: res.GetInvite()}, nil
+}
+
+// RevokeInvite asks the master to revoke an invite code to this game. Only the game's creator may revoke invites.
+func (s *GameServer) RevokeInvite(ctx context.Context, in *pb.RevokeInviteRequest) (*pb.RevokeInviteResponse, error) {
+	if _, err := s.updateAccess(ctx, &pb.UpdateAccessRequest{
+		Change: &pb.UpdateAccessRequest_RevokeInvite{RevokeInvite: in},
+	}); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeInviteResponse{}, nil
+}
+
+// UpdateAllowList asks the master to change the players that may join this game without an invite code.
+// Only the game's creator may change the allow-list.
+func (s *GameServer) UpdateAllowList(ctx context.Context, in *pb.UpdateAllowListRequest) (*pb.UpdateAllowListResponse, error) {
+	res, err := s.updateAccess(ctx, &pb.UpdateAccessRequest{
+		Change: &pb.UpdateAccessRequest_UpdateAllowList{UpdateAllowList: in},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateAllowListResponse{AllowedPlayerIds: res.GetAccess().GetAllowedPlayerIds()}, nil
+}
+
+// updateAccess forwards the access change to the master on behalf of the calling player.
+func (s *GameServer) updateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
+	}
+	in.PlayerId = pid
+	return s.masterCli.UpdateAccess(ctx, in)
 }
 
-// Leave leaves this game.
+// Leave asks the master to remove the calling player from this game.
 func (s *GameServer) Leave(ctx context.Context, in *pb.LeaveRequest) (*pb.LeaveResponse, error) {
-	return gameImplementation.Leave(ctx, in)
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
+	}
+	res, err := s.masterCli.RemovePlayers(ctx, &pb.RemovePlayersRequest{
+		PlayerIds: []string{pid},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The master updates every other slave, this one applies the state from the response.
+	_, err = s.c.serverSlave.updateState(ctx, &pb.UpdateStateRequest{State: res.GetState()})
+	if err != nil && status.Code(err) != codes.Aborted {
+		log.Printf("unable to apply state after leaving: %v", err)
+	}
+	return &pb.LeaveResponse{}, nil
 }
 
-// PostVote posts a vote to this game.
+// PostVote posts a vote to this game. When votes are applied immediately the vote is sent to the master to be applied.
 func (s *GameServer) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
-	return gameImplementation.PostVote(ctx, in)
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
+	}
+	if in.GetVote() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing vote")
+	}
+	in.GetVote().PlayerId = pid
+
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if metadataRes.GetMetadata().GetRules().GetVoteAppliedImmediately() != nil {
+		if _, err := s.masterCli.ApplyVote(ctx, &pb.ApplyVoteRequest{Vote: in.GetVote()}); err != nil {
+			return nil, err
+		}
+		return &pb.PostVoteResponse{}, nil
+	}
+	res, err := s.c.gameImplementation.PostVote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	// The master only needs to know who voted to close the round early once every player has voted.
+	if !metadataRes.GetMetadata().GetRules().GetVoteAppliedAfterTally().GetWaitFullTimeout() {
+		s.voters.add(in.GetVote(), false)
+	}
+	return res, nil
+}
+
+// RetractVote removes the calling player's vote of the current round from this slave.
+func (s *GameServer) RetractVote(ctx context.Context, in *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	pid, err := grpcplayertokens.ValidatedPlayerIDFromIncomingContext(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing player id from incoming context")
+	}
+	in.PlayerId = pid
+	metadataRes, err := s.c.gameImplementation.Metadata(ctx, &pb.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.c.gameImplementation.RetractVote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if !metadataRes.GetMetadata().GetRules().GetVoteAppliedAfterTally().GetWaitFullTimeout() {
+		s.voters.add(res.GetVote(), true)
+	}
+	return res, nil
 }
 
-// Status returns the status of this game (and/or the underlying server).
+// Status returns the status of this game and this slave.
 func (s *GameServer) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
-	return gameImplementation.Status(ctx, in)
+	res, err := s.c.gameImplementation.Status(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	res.InstanceId = s.c.instanceID
+	res.Role = pb.StatusResponse_SLAVE
+	res.MasterId = s.c.serverSlave.currentMasterID()
+	return res, nil
+}
+
+// WatchGame streams every change to this game as seen by this server.
+func (s *GameServer) WatchGame(in *pb.WatchGameRequest, stream pb.GameServer_WatchGameServer) error {
+	return s.c.gameImplementation.WatchGame(in, stream)
 }
@@ -3,34 +3,179 @@ package gameslave
 
 import (
 	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
 	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
 )
 
+const (
+	// heartbeatInterval is how often this slave sends a heartbeat to the master.
+	heartbeatInterval = 5 * time.Second
+	// masterLostAfter is how many heartbeats in a row may fail before the master is considered lost.
+	masterLostAfter = 3
+)
+
 // GameServerSlave implements the GameServerSlave service.
 type GameServerSlave struct {
+	// c is the controller of the game this slave serves.
+	c *Controller
+	// mux guards masterID which changes when re-registering with a new master.
+	mux                 sync.Mutex
 	masterID            string
+	returnAddress       string
 	masterCli           pb.GameServerMasterClient
+	masters             *masterConns
 	playersRegistrarCli pr.PlayersRegistrarClient
+	// Set while resyncing with the master.
+	resyncing int32
 }
 
 // ChangeAcceptingVotes is called by GameServerMasters to set this GameServerSlave to no longer accept votes. Typically done at end of a voting round.
 func (s *GameServerSlave) ChangeAcceptingVotes(ctx context.Context, in *pb.ChangeAcceptingVotesRequest) (*pb.ChangeAcceptingVotesResponse, error) {
-	return gameImplementation.ChangeAcceptingVotes(ctx, in)
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.gameImplementation.ChangeAcceptingVotes(ctx, in)
 }
 
 // GetVotes is called by GameServerMasters get all votes received by this GameServerSlave for the current round.
 func (s *GameServerSlave) GetVotes(ctx context.Context, in *pb.GetVotesRequest) (*pb.GetVotesResponse, error) {
-	return gameImplementation.GetVotes(ctx, in)
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.gameImplementation.GetVotes(ctx, in)
 }
 
 // UpdateMetadata is called by GameServerMasters to update this slave's metadata.
 func (s *GameServerSlave) UpdateMetadata(ctx context.Context, in *pb.UpdateMetadataRequest) (*pb.UpdateMetadataResponse, error) {
-	return gameImplementation.UpdateMetadata(ctx, in)
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.gameImplementation.UpdateMetadata(ctx, in)
 }
 
 // UpdateState is called by GameServerMasters to update this slave's state of the game.
+// If the update skips a state version this slave resyncs with the master.
 func (s *GameServerSlave) UpdateState(ctx context.Context, in *pb.UpdateStateRequest) (*pb.UpdateStateResponse, error) {
-	return gameImplementation.UpdateState(ctx, in)
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	return s.updateState(ctx, in)
+}
+
+// updateState applies the update, resyncing with the master if it skips a state version.
+func (s *GameServerSlave) updateState(ctx context.Context, in *pb.UpdateStateRequest) (*pb.UpdateStateResponse, error) {
+	res, err := s.c.gameImplementation.UpdateState(ctx, in)
+	if status.Code(err) == codes.OutOfRange {
+		go s.resync()
+	}
+	return res, err
+}
+
+// heartbeat periodically lets the master know this slave is alive and resyncs if the master has a newer state.
+// If the master no longer knows this slave, or cannot be reached for several heartbeats, this slave re-registers.
+// Returns once stop is closed.
+func (s *GameServerSlave) heartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		err := s.sendHeartbeat()
+		switch {
+		case status.Code(err) == codes.NotFound:
+			log.Printf("master no longer knows this slave, re-registering: %v", err)
+			failures = 0
+			s.reregister(stop)
+		case err != nil:
+			failures++
+			log.Printf("unable to send heartbeat to master (%d in a row): %v", failures, err)
+			if failures >= masterLostAfter {
+				log.Printf("lost contact with master, re-registering")
+				failures = 0
+				s.reregister(stop)
+			}
+		default:
+			failures = 0
+		}
+	}
+}
+
+// sendHeartbeat sends a single heartbeat to the master, resyncing if this slave is behind.
+// Returns the error of the heartbeat call, if any.
+func (s *GameServerSlave) sendHeartbeat() error {
+	ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+	defer cancel()
+	stateRes, err := s.c.gameImplementation.State(ctx, &pb.StateRequest{})
+	if err != nil {
+		log.Printf("unable to get state for heartbeat: %v", err)
+		return nil
+	}
+	version := stateRes.GetState().GetVersion()
+	res, err := s.masterCli.Heartbeat(ctx, &pb.HeartbeatRequest{StateVersion: version})
+	if err != nil {
+		return err
+	}
+	if res.GetStateVersion() > version {
+		go s.resync()
+	}
+	return nil
+}
+
+// resync replaces this slave's state and history with the master's latest. Only one resync runs at a time.
+func (s *GameServerSlave) resync() {
+	if !atomic.CompareAndSwapInt32(&s.resyncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.resyncing, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+	defer cancel()
+	res, err := s.masterCli.Resync(ctx, &pb.ResyncRequest{})
+	if err != nil {
+		log.Printf("unable to resync with master: %v", err)
+		return
+	}
+	_, err = s.c.gameImplementation.UpdateState(ctx, &pb.UpdateStateRequest{
+		State:   res.GetState(),
+		History: res.GetHistory(),
+		Resync:  true,
+	})
+	if err != nil && status.Code(err) != codes.Aborted {
+		log.Printf("unable to apply resync from master: %v", err)
+	}
+}
+
+// UpdateTally is called by GameServerMasters with the current round's votes merged from every server.
+func (s *GameServerSlave) UpdateTally(ctx context.Context, in *pb.UpdateTallyRequest) (*pb.UpdateTallyResponse, error) {
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.gameImplementation.UpdateTally(ctx, in)
+}
+
+// StopGame is called by GameServerMasters once the game has been stopped. This slave stops accepting votes and shuts down.
+func (s *GameServerSlave) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
+	if err := s.validateMaster(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := s.c.gameImplementation.ChangeAcceptingVotes(ctx, &pb.ChangeAcceptingVotesRequest{AcceptingVotes: false}); err != nil {
+		return nil, err
+	}
+	log.Printf("game stopped by master: %q", in.GetReason())
+
+	// Shutting down gracefully waits for this RPC to finish.
+	go s.c.onStop()
+	return &pb.StopGameResponse{}, nil
 }
@@ -0,0 +1,93 @@
+package gameslave
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/sambdavidson/community-chess/src/gameserver/registry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// masterConns is a grpc.ClientConnInterface sending every call to the current one of several masters of the game.
+// A GameServerMasterClient built on it follows whichever master leads the game once the slave moves to it.
+type masterConns struct {
+	mux       sync.Mutex
+	addresses []string
+	conns     []*grpc.ClientConn
+	current   int
+}
+
+// dialMasters connects to every master address. Every call names the game so masters hosting many games route it.
+func dialMasters(gameID string, addresses []string, tlsConfig *tls.Config) (*masterConns, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no master addresses")
+	}
+	m := &masterConns{addresses: addresses}
+	opts := append(registry.WithGameID(gameID), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	for _, a := range addresses {
+		conn, err := grpc.Dial(a, opts...)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to dial master %s: %v", a, err)
+		}
+		m.conns = append(m.conns, conn)
+	}
+	return m, nil
+}
+
+// Invoke sends the unary call to the current master.
+func (m *masterConns) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return m.conn().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream opens the stream to the current master.
+func (m *masterConns) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return m.conn().NewStream(ctx, desc, method, opts...)
+}
+
+// tryEach calls try with the current master and then each other master in turn until it succeeds, leaving the
+// successful master current. Returns the error of the last attempt if none succeed.
+func (m *masterConns) tryEach(try func(address string) error) error {
+	var err error
+	for range m.addresses {
+		address := m.currentAddress()
+		if err = try(address); err == nil {
+			return nil
+		}
+		m.next(address)
+	}
+	return err
+}
+
+// currentAddress returns the address of the current master.
+func (m *masterConns) currentAddress() string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.addresses[m.current]
+}
+
+// next moves on from the master at address to the next one, unless another caller already moved on.
+func (m *masterConns) next(address string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.addresses[m.current] == address {
+		m.current = (m.current + 1) % len(m.addresses)
+	}
+}
+
+func (m *masterConns) conn() *grpc.ClientConn {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.conns[m.current]
+}
+
+// Close closes the connections to every master.
+func (m *masterConns) Close() {
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+}
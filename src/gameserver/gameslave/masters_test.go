@@ -0,0 +1,39 @@
+package gameslave
+
+import (
+	"crypto/tls"
+	"fmt"
+	"testing"
+)
+
+func TestMasterConnsTryEach(t *testing.T) {
+	m, err := dialMasters("g1", []string{"m1:8090", "m2:8090", "m3:8090"}, &tls.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tried := []string{}
+	err = m.tryEach(func(address string) error {
+		tried = append(tried, address)
+		if address != "m2:8090" {
+			return fmt.Errorf("%s is a standby", address)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tried) != 2 || m.currentAddress() != "m2:8090" {
+		t.Errorf("tried %v and stayed on %s; want to try m1 then stay on leader m2", tried, m.currentAddress())
+	}
+
+	tried = nil
+	err = m.tryEach(func(address string) error {
+		tried = append(tried, address)
+		return fmt.Errorf("%s is down", address)
+	})
+	if err == nil || len(tried) != 3 {
+		t.Errorf("tried %v with error %v; want every master tried once and an error", tried, err)
+	}
+}
@@ -0,0 +1,117 @@
+package gameslave
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/lib/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+const (
+	// reregisterMinBackoff is how long to wait after the first failed attempt to re-register with the master.
+	reregisterMinBackoff = time.Second
+	// reregisterMaxBackoff is the longest wait between attempts to re-register with the master.
+	reregisterMaxBackoff = 30 * time.Second
+)
+
+// reregister adds this slave to the master again, doubling the wait between failed attempts.
+// Returns once registered or stop is closed.
+func (s *GameServerSlave) reregister(stop <-chan struct{}) {
+	wait := reregisterMinBackoff
+	for {
+		err := s.register()
+		if err == nil {
+			return
+		}
+		log.Printf("unable to re-register with master, retrying in %v: %v", wait, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > reregisterMaxBackoff {
+			wait = reregisterMaxBackoff
+		}
+	}
+}
+
+// register adds this slave to the leading master, trying each master in turn. Standby masters refuse slaves.
+func (s *GameServerSlave) register() error {
+	return s.masters.tryEach(func(address string) error {
+		err := s.registerWithCurrent()
+		if err != nil {
+			log.Printf("unable to register with master at %s: %v", address, err)
+		}
+		return err
+	})
+}
+
+// registerWithCurrent adds this slave to the current master, adopts the master's identity and game, and re-adds
+// players known to this slave that the master does not know so they do not need to rejoin.
+func (s *GameServerSlave) registerWithCurrent() error {
+	ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+	defer cancel()
+	res, err := s.masterCli.AddSlave(ctx, &pb.AddSlaveRequest{
+		ReturnAddress: s.returnAddress,
+	})
+	if err != nil {
+		return err
+	}
+	if res.GetGame().GetType() != s.c.gameType {
+		return status.Errorf(codes.FailedPrecondition, "master is running game type %v; want %v", res.GetGame().GetType(), s.c.gameType)
+	}
+
+	// Players must be compared before the master's game replaces this slave's.
+	missing := s.c.gameImplementation.MissingPlayers(res.GetGame())
+	s.setMasterID(res.GetMasterId())
+	if _, err := s.c.gameImplementation.Initialize(ctx, &pb.InitializeRequest{Game: res.GetGame()}); err != nil {
+		return err
+	}
+	log.Printf("re-registered with master %s, re-adding %d players", res.GetMasterId(), len(missing))
+
+	for _, p := range missing {
+		addRes, err := s.masterCli.AddPlayers(ctx, &pb.AddPlayersRequest{
+			Players: []*pb.AddPlayersRequest_NewPlayer{p},
+		})
+		if err != nil {
+			log.Printf("unable to re-add player %s: %v", p.GetPlayerId(), err)
+			continue
+		}
+		if _, err := s.updateState(ctx, &pb.UpdateStateRequest{State: addRes.GetState()}); err != nil && status.Code(err) != codes.Aborted {
+			log.Printf("unable to apply state after re-adding player %s: %v", p.GetPlayerId(), err)
+		}
+	}
+	return nil
+}
+
+// currentMasterID returns the instance ID of this slave's master.
+func (s *GameServerSlave) currentMasterID() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.masterID
+}
+
+// setMasterID changes the instance ID of this slave's master.
+func (s *GameServerSlave) setMasterID(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.masterID = id
+}
+
+// validateMaster validates the certificate within ctx is this slave's master.
+// If anything goes wrong returns a GRPC status error.
+func (s *GameServerSlave) validateMaster(ctx context.Context) error {
+	cert, err := auth.X509CertificateFromContext(ctx)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "couldn't get master peer certificate: %v", err)
+	}
+	if masterID := s.currentMasterID(); cert.Subject.CommonName != masterID {
+		return status.Errorf(codes.InvalidArgument, "master certificate subject is not expected got: %s; want: %s", cert.Subject.CommonName, masterID)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package gameslave
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// voterReportInterval is how often the players that voted on this slave are reported to the master.
+const voterReportInterval = time.Second
+
+// voterReports batches the players that voted on this slave, or retracted their vote, until they are reported to
+// the master, so the master gets one ReportVoters call per interval rather than one per vote. Only the latest report
+// of each player is kept and batches are sent one at a time, so a retraction never reaches the master before the
+// vote it cancels.
+type voterReports struct {
+	mux sync.Mutex
+	// Player ID to its latest report not yet sent.
+	pending map[string]voterReport
+}
+
+// voterReport is a player's vote, or its retraction.
+type voterReport struct {
+	vote      *messages.Vote
+	retracted bool
+}
+
+// add queues the player's vote, or its retraction, to be reported with the next batch, replacing any earlier
+// report of the player.
+func (r *voterReports) add(v *messages.Vote, retracted bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.pending == nil {
+		r.pending = map[string]voterReport{}
+	}
+	r.pending[v.GetPlayerId()] = voterReport{vote: v, retracted: retracted}
+}
+
+// take returns the queued reports as a request, votes and retractions in player ID order, and clears the queue.
+func (r *voterReports) take() *pb.ReportVotersRequest {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	players := make([]string, 0, len(r.pending))
+	for p := range r.pending {
+		players = append(players, p)
+	}
+	sort.Strings(players)
+	req := &pb.ReportVotersRequest{}
+	for _, p := range players {
+		if report := r.pending[p]; report.retracted {
+			req.Retracted = append(req.Retracted, report.vote)
+		} else {
+			req.Votes = append(req.Votes, report.vote)
+		}
+	}
+	r.pending = nil
+	return req
+}
+
+// requeue queues reports that failed to be sent again, unless their player has been reported again since.
+func (r *voterReports) requeue(req *pb.ReportVotersRequest) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.pending == nil {
+		r.pending = map[string]voterReport{}
+	}
+	for retracted, votes := range map[bool][]*messages.Vote{false: req.GetVotes(), true: req.GetRetracted()} {
+		for _, v := range votes {
+			if _, ok := r.pending[v.GetPlayerId()]; !ok {
+				r.pending[v.GetPlayerId()] = voterReport{vote: v, retracted: retracted}
+			}
+		}
+	}
+}
+
+// reportVoters reports the queued voters to the master every voterReportInterval. Returns once stop is closed.
+func (s *GameServer) reportVoters(stop <-chan struct{}) {
+	ticker := time.NewTicker(voterReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.flushVoters()
+	}
+}
+
+// flushVoters reports the queued voters to the master in one batch. If the report fails they are queued again.
+func (s *GameServer) flushVoters() {
+	req := s.voters.take()
+	if len(req.GetVotes()) == 0 && len(req.GetRetracted()) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), masterCallTimeout)
+	defer cancel()
+	if _, err := s.masterCli.ReportVoters(ctx, req); err != nil {
+		log.Printf("unable to report %d voters to master: %v", len(req.GetVotes())+len(req.GetRetracted()), err)
+		s.voters.requeue(req)
+	}
+}
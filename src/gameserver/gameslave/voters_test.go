@@ -0,0 +1,61 @@
+package gameslave
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// testMaster records the ReportVoters calls it receives.
+type testMaster struct {
+	pb.GameServerMasterClient
+	reports []*pb.ReportVotersRequest
+	err     error
+}
+
+func (m *testMaster) ReportVoters(ctx context.Context, in *pb.ReportVotersRequest, opts ...grpc.CallOption) (*pb.ReportVotersResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.reports = append(m.reports, in)
+	return &pb.ReportVotersResponse{}, nil
+}
+
+func TestFlushVoters(t *testing.T) {
+	m := &testMaster{}
+	s := &GameServer{masterCli: m}
+	s.flushVoters()
+	if len(m.reports) != 0 {
+		t.Fatalf("got %d reports without voters; want none", len(m.reports))
+	}
+
+	for _, p := range []string{"p2", "p1", "p2"} {
+		s.voters.add(&messages.Vote{PlayerId: p}, false)
+	}
+	m.err = fmt.Errorf("master unavailable")
+	s.flushVoters()
+	m.err = nil
+	s.voters.add(&messages.Vote{PlayerId: "p3"}, false)
+	// p1 retracted its vote before the failed batch could be sent again, only the retraction is reported.
+	s.voters.add(&messages.Vote{PlayerId: "p1"}, true)
+	s.flushVoters()
+	if len(m.reports) != 1 {
+		t.Fatalf("got %d reports; want the failed batch sent again with the next one", len(m.reports))
+	}
+	got := []string{}
+	for _, v := range m.reports[0].GetVotes() {
+		got = append(got, v.GetPlayerId())
+	}
+	retracted := []string{}
+	for _, v := range m.reports[0].GetRetracted() {
+		retracted = append(retracted, v.GetPlayerId())
+	}
+	if fmt.Sprint(got, retracted) != "[p2 p3] [p1]" {
+		t.Errorf("got voters %v and retractions %v reported; want voters [p2 p3] and retraction [p1]", got, retracted)
+	}
+}
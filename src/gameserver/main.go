@@ -8,6 +8,14 @@ go run .\src\gameserver --slave=false --game_port=8080 --master_port=8090 --game
 SLAVE
 go run .\src\gameserver --slave --game_port=8070 --slave_port=8071 --game_id=88888888-4444-2222-1111-000000000000 --debug
 
+MASTER WITH STANDBY, slaves pass every master in --master_address
+go run .\src\gameserver --slave=false --game_port=8080 --master_port=8090 --game_id=88888888-4444-2222-1111-000000000000 --game_store_dir=.\gamestore --standby --debug
+go run .\src\gameserver --slave=false --game_port=8081 --master_port=8091 --game_id=88888888-4444-2222-1111-000000000000 --game_store_dir=.\gamestore --standby --debug
+
+MANY GAMES, clients name the game in the game-id header
+go run .\src\gameserver --slave=false --game_port=8080 --master_port=8090 --game_id=88888888-4444-2222-1111-000000000000,88888888-4444-2222-1111-000000000001 --debug
+go run .\src\gameserver --slave --game_port=8070 --slave_port=8071 --game_id=88888888-4444-2222-1111-000000000000,88888888-4444-2222-1111-000000000001 --debug
+
 */
 
 import (
@@ -19,6 +27,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"text/tabwriter"
 	"time"
@@ -31,6 +40,8 @@ import (
 	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/sambdavidson/community-chess/src/gameserver/gamemaster"
 	"github.com/sambdavidson/community-chess/src/gameserver/gameslave"
+	"github.com/sambdavidson/community-chess/src/gameserver/registry"
+	"github.com/sambdavidson/community-chess/src/gameserver/store"
 	"github.com/sambdavidson/community-chess/src/lib/debug"
 	gs "github.com/sambdavidson/community-chess/src/proto/services/games/server"
 	pr "github.com/sambdavidson/community-chess/src/proto/services/players/registrar"
@@ -45,10 +56,15 @@ var (
 	slavePort  = flag.Int("slave_port", freePort(), "port the GameServerSlave service accepts connections, if enabled")
 
 	slave                  = flag.Bool("slave", false, "whether or not this server is a GameServerSlave")
-	masterAddress          = flag.String("master_address", "", "addres of GameServerMaster; must be set if --slave is also set")
+	masterAddress          = flag.String("master_address", "", "comma separated addresses of the game's GameServerMasters; must be set if --slave is also set")
 	playerRegistrarAddress = flag.String("player_registar_address", "playerregistrar:443", "address of the Player Registrar")
-	gameID                 = flag.String("game_id", "", "game_id to use, TODO for now is a UUID random generated at startup")
+	gameID                 = flag.String("game_id", "", "comma separated game_ids this server hosts, TODO for now is a UUID random generated at startup")
+	slaveGameID            = flag.String("slave_game_id", "", "comma separated game_ids a GameServerMaster also hosts as a GameServerSlave")
 	instanceID             = flag.String("instance_id", uuid.New().String(), "instance_id which uniquely identifies this running gameserver instance")
+	gameStoreDir           = flag.String("game_store_dir", "", "directory a GameServerMaster persists its game in and reloads it from on restart; if empty the game is not persisted")
+	standby                = flag.Bool("standby", false, "whether or not GameServerMasters sharing --game_store_dir elect a leader, the others standing by to take over if it fails")
+	aggregateVotes         = flag.Bool("aggregate_votes", false, "whether or not a GameServerMaster collects a tally of each slave's votes instead of every vote when a round closes")
+	tallyRefreshInterval   = flag.Duration("tally_refresh_interval", 2*time.Second, "how often a GameServerMaster merges the votes of every slave into the live tally shown to all players; if 0 each server shows only its own votes")
 )
 
 var (
@@ -58,8 +74,11 @@ var (
 
 // State
 var (
-	slaveController  *gameslave.Controller
-	masterController *gamemaster.Controller
+	games *registry.Registry
+
+	// Game ID to the close function of its controller, guarded by controllersMux.
+	controllersMux sync.Mutex
+	controllers    = map[string]func(){}
 
 	gameServer   *grpc.Server
 	masterServer *grpc.Server
@@ -82,101 +101,166 @@ func main() {
 
 	go handleSIGINT()
 
-	if *slave { // Slave
-		slaveTLS, err := gameSlaveTLSConfig()
-		if err != nil {
+	games = registry.New()
+	masterGameIDs, slaveGameIDs := splitIDs(*gameID), splitIDs(*slaveGameID)
+	if *slave {
+		masterGameIDs, slaveGameIDs = nil, append(masterGameIDs, slaveGameIDs...)
+	}
+
+	var serverTLS *tls.Config
+	var masterTLS, slaveTLS *tls.Config
+	var err error
+	if len(slaveGameIDs) > 0 {
+		if slaveTLS, err = gameSlaveTLSConfig(); err != nil {
 			log.Fatalf("failed to build slave TLS config: %v", err)
 		}
-		playersRegistrarClient, playersRegistrarConn, err = setupPlayerRegistrar(*playerRegistrarAddress, slaveTLS)
-		if err != nil {
-			log.Fatalf("failed to connect to playerristrar service as slave: %v", err)
+		serverTLS = slaveTLS
+	}
+	if len(masterGameIDs) > 0 {
+		if masterTLS, err = gameMasterTLSConfig(); err != nil {
+			log.Fatalf("failed to build master TLS config: %v", err)
 		}
-		slaveController, err = gameslave.NewGameSlaveController(gameslave.Opts{
-			GameID:              *gameID,
-			SlaveAddress:        slaveAddress(),
-			MasterAddress:       *masterAddress,
-			SlaveTLSConfig:      slaveTLS,
-			PlayersRegistrarCli: playersRegistrarClient,
-		})
-		if err != nil {
-			log.Fatalf("failed to build GameSlaveController: %v", err)
+		serverTLS = masterTLS
+	}
+	if serverTLS == nil {
+		log.Fatalf("no games to host, --game_id must be set")
+	}
+	playersRegistrarClient, playersRegistrarConn, err = setupPlayerRegistrar(*playerRegistrarAddress, serverTLS)
+	if err != nil {
+		log.Fatalf("failed to connect to playerristrar service: %v", err)
+	}
+
+	if len(masterGameIDs) > 0 { // Masters
+		var gameStore store.Store
+		var leases store.Leases
+		if *gameStoreDir != "" {
+			fileStore, err := store.NewFileStore(*gameStoreDir)
+			if err != nil {
+				log.Fatalf("failed to open game store: %v", err)
+			}
+			gameStore = fileStore
+			if *standby {
+				leases = fileStore
+			}
+		} else if *standby {
+			log.Fatalf("--standby requires --game_store_dir")
+		}
+		for _, id := range masterGameIDs {
+			id := id
+			masterController, err := gamemaster.NewGameMasterController(gamemaster.Opts{
+				InstanceID:           *instanceID,
+				GameID:               id,
+				MasterTLSConfig:      masterTLS,
+				PlayersRegistrarCli:  playersRegistrarClient,
+				Store:                gameStore,
+				Leases:               leases,
+				Address:              fmt.Sprintf("localhost:%d", *masterPort), // TODO
+				OnStop:               func() { stopGame(id) },
+				AggregateVotes:       *aggregateVotes,
+				TallyRefreshInterval: *tallyRefreshInterval,
+			})
+			if err != nil {
+				log.Fatalf("failed to build GameMasterController for game %s: %v", id, err)
+			}
+			hostGame(id, &registry.Game{
+				Server: masterController.GameServerInstance(),
+				Master: masterController.GameServerMasterInstance(),
+			}, masterController.Close)
 		}
-		creds := grpc.Creds(credentials.NewTLS(slaveTLS))
-		gameServer = grpc.NewServer(
-			creds,
+
+		masterServer = grpc.NewServer(
+			grpc.Creds(credentials.NewTLS(masterTLS)),
 			grpc.UnaryInterceptor(
 				middleware.ChainUnaryServer(
 					debug.UnaryServerInterceptor,
-					grpcplayertokens.NewPlayerAuthIngress(grpcplayertokens.PlayerAuthIngressArgs{
-						PlayersRegistrarClient: playersRegistrarClient,
-					}).GetUnaryServerInterceptor(grpcplayertokens.Reject),
+					gamemaster.MasterAuthUnaryServerInterceptor,
 				),
 			),
 		)
-		gs.RegisterGameServerServer(gameServer, slaveController.GameServerInstance())
+		gs.RegisterGameServerMasterServer(masterServer, games.GameServerMasterServer())
+		asyncServe("MasterServer", masterServer, *masterPort)
+	}
+
+	if len(slaveGameIDs) > 0 { // Slaves
+		for _, id := range slaveGameIDs {
+			id := id
+			slaveController, err := gameslave.NewGameSlaveController(gameslave.Opts{
+				InstanceID:          *instanceID,
+				GameID:              id,
+				SlaveAddress:        slaveAddress(),
+				MasterAddresses:     strings.Split(*masterAddress, ","),
+				SlaveTLSConfig:      slaveTLS,
+				PlayersRegistrarCli: playersRegistrarClient,
+				OnStop:              func() { stopGame(id) },
+			})
+			if err != nil {
+				log.Fatalf("failed to build GameSlaveController for game %s: %v", id, err)
+			}
+			hostGame(id, &registry.Game{
+				Server: slaveController.GameServerInstance(),
+				Slave:  slaveController.GameServerSlaveInstance(),
+			}, slaveController.Close)
+		}
 
 		slaveServer = grpc.NewServer(
-			creds,
-			grpc.UnaryInterceptor(
-				middleware.ChainUnaryServer(
-					debug.UnaryServerInterceptor,
-					gameslave.ValidateMasterUnaryServerInterceptor,
-				),
-			),
+			grpc.Creds(credentials.NewTLS(slaveTLS)),
+			grpc.UnaryInterceptor(debug.UnaryServerInterceptor),
 		)
-		gs.RegisterGameServerSlaveServer(slaveServer, slaveController.GameServerSlaveInstance())
-
-		asyncServe("GameServer", gameServer, *gamePort)
+		gs.RegisterGameServerSlaveServer(slaveServer, games.GameServerSlaveServer())
 		asyncServe("SlaveServer", slaveServer, *slavePort)
+	}
 
-	} else { // Master
-		masterTLS, err := gameMasterTLSConfig()
-		if err != nil {
-			log.Fatalf("failed to build master TLS config: %v", err)
-		}
-		playersRegistrarClient, playersRegistrarConn, err = setupPlayerRegistrar(*playerRegistrarAddress, masterTLS)
-		if err != nil {
-			log.Fatalf("failed to connect to playerristrar service as master: %v", err)
-		}
-		masterController, err = gamemaster.NewGameMasterController(gamemaster.Opts{
-			GameID:              *gameID,
-			MasterTLSConfig:     masterTLS,
-			PlayersRegistrarCli: playersRegistrarClient,
-		})
-		if err != nil {
-			log.Fatalf("failed to build GameMasterController: %v", err)
-		}
-		creds := grpc.Creds(credentials.NewTLS(masterTLS))
-		gameServer = grpc.NewServer(
-			creds,
-			grpc.UnaryInterceptor(
-				middleware.ChainUnaryServer(
-					debug.UnaryServerInterceptor,
-					grpcplayertokens.NewPlayerAuthIngress(grpcplayertokens.PlayerAuthIngressArgs{
-						PlayersRegistrarClient: playersRegistrarClient,
-					}).GetUnaryServerInterceptor(grpcplayertokens.Reject),
-				),
+	gameServer = grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS)),
+		grpc.UnaryInterceptor(
+			middleware.ChainUnaryServer(
+				debug.UnaryServerInterceptor,
+				grpcplayertokens.NewPlayerAuthIngress(grpcplayertokens.PlayerAuthIngressArgs{
+					PlayersRegistrarClient: playersRegistrarClient,
+				}).GetUnaryServerInterceptor(grpcplayertokens.Reject),
 			),
-		)
-		gs.RegisterGameServerServer(gameServer, masterController.GameServerInstance())
+		),
+	)
+	gs.RegisterGameServerServer(gameServer, games.GameServerServer())
+	asyncServe("GameServer", gameServer, *gamePort)
 
-		masterServer = grpc.NewServer(
-			creds,
-			grpc.UnaryInterceptor(
-				middleware.ChainUnaryServer(
-					debug.UnaryServerInterceptor,
-					gamemaster.MasterAuthUnaryServerInterceptor,
-				),
-			),
-		)
-		gs.RegisterGameServerMasterServer(masterServer, masterController.GameServerMasterInstance())
+	serverWG.Wait()
+}
 
-		asyncServe("GameServer", gameServer, *gamePort)
-		asyncServe("MasterServer", masterServer, *masterPort)
+// hostGame routes the game's RPCs to it until stopGame is called with its ID.
+func hostGame(id string, g *registry.Game, closeGame func()) {
+	if err := games.Add(id, g); err != nil {
+		log.Fatalf("failed to host game %s: %v", id, err)
+	}
+	controllersMux.Lock()
+	defer controllersMux.Unlock()
+	controllers[id] = closeGame
+}
 
+// stopGame stops hosting the game and closes its controller. Shuts down the servers once no games are left.
+func stopGame(id string) {
+	remaining := games.Remove(id)
+	controllersMux.Lock()
+	closeGame, ok := controllers[id]
+	delete(controllers, id)
+	controllersMux.Unlock()
+	if ok {
+		closeGame()
 	}
+	if remaining == 0 {
+		closeConnections()
+	}
+}
 
-	serverWG.Wait()
+// splitIDs splits the comma separated IDs, dropping empty ones.
+func splitIDs(ids string) []string {
+	var out []string
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
 }
 
 func printConfig() {
@@ -190,8 +274,8 @@ func printConfig() {
 	log.Println("Starting Game Server with configuration")
 	w := &tabwriter.Writer{}
 	w.Init(log.Writer(), 0, 8, 1, '\t', 0)
-	fmt.Fprintf(w, "Slave/Master\tMain Port\t%s Port\tInstance UUID\tGame UUID\tMaster Address\tPR Address\n", slaveOrMaster)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", slaveOrMaster, *gamePort, slaveOrMasterPort, *instanceID, *gameID, *masterAddress, *playerRegistrarAddress)
+	fmt.Fprintf(w, "Slave/Master\tMain Port\t%s Port\tInstance UUID\tGame UUIDs\tSlave Game UUIDs\tMaster Address\tPR Address\n", slaveOrMaster)
+	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", slaveOrMaster, *gamePort, slaveOrMasterPort, *instanceID, *gameID, *slaveGameID, *masterAddress, *playerRegistrarAddress)
 	fmt.Fprintln(w)
 	w.Flush()
 }
@@ -231,11 +315,12 @@ func handleSIGINT() {
 }
 
 func closeConnections() {
-	if slaveController != nil {
-		slaveController.Close()
-	}
-	if masterController != nil {
-		masterController.Close()
+	controllersMux.Lock()
+	closing := controllers
+	controllers = map[string]func(){}
+	controllersMux.Unlock()
+	for _, closeGame := range closing {
+		closeGame()
 	}
 	if playersRegistrarConn != nil {
 		playersRegistrarConn.Close()
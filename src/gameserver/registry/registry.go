@@ -0,0 +1,110 @@
+// Package registry lets one process host many games by routing every RPC to the game named in its metadata.
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// GameIDHeader is the metadata header naming the game an RPC is for. Requests without it are routed to the only
+// game hosted, if there is exactly one, so clients of single game processes need not send it.
+const GameIDHeader = "game-id"
+
+// Game is everything a process serves for one game. Master is nil unless the process is the game's master,
+// Slave is nil unless it is one of the game's slaves.
+type Game struct {
+	Server pb.GameServerServer
+	Master pb.GameServerMasterServer
+	Slave  pb.GameServerSlaveServer
+}
+
+// Registry holds every game hosted by a process keyed by game ID.
+type Registry struct {
+	mux   sync.Mutex
+	games map[string]*Game
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{
+		games: map[string]*Game{},
+	}
+}
+
+// Add hosts the game. Returns an AlreadyExists error if the game is already hosted.
+func (r *Registry) Add(gameID string, g *Game) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.games[gameID]; ok {
+		return status.Errorf(codes.AlreadyExists, "game %s is already hosted", gameID)
+	}
+	r.games[gameID] = g
+	return nil
+}
+
+// Remove stops hosting the game and returns how many games are still hosted.
+func (r *Registry) Remove(gameID string) int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.games, gameID)
+	return len(r.games)
+}
+
+// GameIDs returns the IDs of every hosted game in order.
+func (r *Registry) GameIDs() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	out := make([]string, 0, len(r.games))
+	for id := range r.games {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lookup returns the game named in the incoming metadata of ctx.
+// If anything goes wrong returns a GRPC status error.
+func (r *Registry) lookup(ctx context.Context) (*Game, error) {
+	var gameID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(GameIDHeader); len(ids) > 0 {
+			gameID = ids[0]
+		}
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if gameID == "" {
+		if len(r.games) != 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "missing %s header, this process hosts %d games", GameIDHeader, len(r.games))
+		}
+		for _, g := range r.games {
+			return g, nil
+		}
+	}
+	g, ok := r.games[gameID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "game %s is not hosted here", gameID)
+	}
+	return g, nil
+}
+
+// WithGameID returns dial options adding the game's ID to the metadata of every RPC made on the connection.
+func WithGameID(gameID string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, GameIDHeader, gameID), method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, GameIDHeader, gameID), desc, cc, method, opts...)
+		}),
+	}
+}
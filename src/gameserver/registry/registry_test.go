@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// testServer is a GameServerServer whose Status names the game it serves.
+type testServer struct {
+	pb.GameServerServer
+	gameID string
+}
+
+func (s *testServer) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+	return &pb.StatusResponse{InstanceId: s.gameID}, nil
+}
+
+func TestRouteByGameID(t *testing.T) {
+	r := New()
+	for _, id := range []string{"g1", "g2"} {
+		if err := r.Add(id, &Game{Server: &testServer{gameID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := r.GameServerServer()
+
+	for _, id := range []string{"g1", "g2"} {
+		res, err := srv.Status(withGameID(id), &pb.StatusRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetInstanceId() != id {
+			t.Errorf("request for game %s was routed to game %s", id, res.GetInstanceId())
+		}
+	}
+	if _, err := srv.Status(withGameID("g3"), &pb.StatusRequest{}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v for a game not hosted; want NotFound", err)
+	}
+	if _, err := srv.Status(context.Background(), &pb.StatusRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v for a request without a game ID; want InvalidArgument", err)
+	}
+	if _, err := r.GameServerMasterServer().Heartbeat(withGameID("g1"), &pb.HeartbeatRequest{}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v for a master RPC to a game without a master; want NotFound", err)
+	}
+}
+
+func TestRouteToOnlyGame(t *testing.T) {
+	r := New()
+	if err := r.Add("g1", &Game{Server: &testServer{gameID: "g1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add("g1", &Game{}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v adding a hosted game again; want AlreadyExists", err)
+	}
+
+	res, err := r.GameServerServer().Status(context.Background(), &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetInstanceId() != "g1" {
+		t.Errorf("request without a game ID was routed to game %q; want the only game g1", res.GetInstanceId())
+	}
+
+	if remaining := r.Remove("g1"); remaining != 0 {
+		t.Errorf("got %d games remaining; want 0", remaining)
+	}
+	if _, err := r.GameServerServer().Status(context.Background(), &pb.StatusRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v once no games are hosted; want InvalidArgument", err)
+	}
+}
+
+func withGameID(id string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(GameIDHeader, id))
+}
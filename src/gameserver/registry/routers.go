@@ -0,0 +1,331 @@
+package registry
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sambdavidson/community-chess/src/proto/services/games/server"
+)
+
+// GameServerServer returns a GameServerServer routing every RPC to the GameServer of the game it names.
+func (r *Registry) GameServerServer() pb.GameServerServer {
+	return &gameServerRouter{r}
+}
+
+// GameServerMasterServer returns a GameServerMasterServer routing every RPC to the master of the game it names.
+func (r *Registry) GameServerMasterServer() pb.GameServerMasterServer {
+	return &masterRouter{r}
+}
+
+// GameServerSlaveServer returns a GameServerSlaveServer routing every RPC to the slave of the game it names.
+func (r *Registry) GameServerSlaveServer() pb.GameServerSlaveServer {
+	return &slaveRouter{r}
+}
+
+type gameServerRouter struct {
+	r *Registry
+}
+
+func (g *gameServerRouter) server(ctx context.Context) (pb.GameServerServer, error) {
+	game, err := g.r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return game.Server, nil
+}
+
+func (g *gameServerRouter) Game(ctx context.Context, in *pb.GameRequest) (*pb.GameResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Game(ctx, in)
+}
+
+func (g *gameServerRouter) Metadata(ctx context.Context, in *pb.MetadataRequest) (*pb.MetadataResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Metadata(ctx, in)
+}
+
+func (g *gameServerRouter) State(ctx context.Context, in *pb.StateRequest) (*pb.StateResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.State(ctx, in)
+}
+
+func (g *gameServerRouter) History(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.History(ctx, in)
+}
+
+func (g *gameServerRouter) Join(ctx context.Context, in *pb.JoinRequest) (*pb.JoinResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Join(ctx, in)
+}
+
+func (g *gameServerRouter) Leave(ctx context.Context, in *pb.LeaveRequest) (*pb.LeaveResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Leave(ctx, in)
+}
+
+func (g *gameServerRouter) PostVote(ctx context.Context, in *pb.PostVoteRequest) (*pb.PostVoteResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.PostVote(ctx, in)
+}
+
+func (g *gameServerRouter) RetractVote(ctx context.Context, in *pb.RetractVoteRequest) (*pb.RetractVoteResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.RetractVote(ctx, in)
+}
+
+func (g *gameServerRouter) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Status(ctx, in)
+}
+
+func (g *gameServerRouter) CreateInvite(ctx context.Context, in *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.CreateInvite(ctx, in)
+}
+
+func (g *gameServerRouter) RevokeInvite(ctx context.Context, in *pb.RevokeInviteRequest) (*pb.RevokeInviteResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.RevokeInvite(ctx, in)
+}
+
+func (g *gameServerRouter) UpdateAllowList(ctx context.Context, in *pb.UpdateAllowListRequest) (*pb.UpdateAllowListResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.UpdateAllowList(ctx, in)
+}
+
+func (g *gameServerRouter) SwitchTeam(ctx context.Context, in *pb.SwitchTeamRequest) (*pb.SwitchTeamResponse, error) {
+	srv, err := g.server(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.SwitchTeam(ctx, in)
+}
+
+func (g *gameServerRouter) WatchGame(in *pb.WatchGameRequest, stream pb.GameServer_WatchGameServer) error {
+	srv, err := g.server(stream.Context())
+	if err != nil {
+		return err
+	}
+	return srv.WatchGame(in, stream)
+}
+
+type masterRouter struct {
+	r *Registry
+}
+
+func (m *masterRouter) master(ctx context.Context) (pb.GameServerMasterServer, error) {
+	game, err := m.r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if game.Master == nil {
+		return nil, status.Error(codes.NotFound, "this process is not the game's master")
+	}
+	return game.Master, nil
+}
+
+func (m *masterRouter) Initialize(ctx context.Context, in *pb.InitializeRequest) (*pb.InitializeResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Initialize(ctx, in)
+}
+
+func (m *masterRouter) AddSlave(ctx context.Context, in *pb.AddSlaveRequest) (*pb.AddSlaveResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.AddSlave(ctx, in)
+}
+
+func (m *masterRouter) AddPlayers(ctx context.Context, in *pb.AddPlayersRequest) (*pb.AddPlayersResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.AddPlayers(ctx, in)
+}
+
+func (m *masterRouter) RemovePlayers(ctx context.Context, in *pb.RemovePlayersRequest) (*pb.RemovePlayersResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.RemovePlayers(ctx, in)
+}
+
+func (m *masterRouter) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.StopGame(ctx, in)
+}
+
+func (m *masterRouter) ApplyVote(ctx context.Context, in *pb.ApplyVoteRequest) (*pb.ApplyVoteResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.ApplyVote(ctx, in)
+}
+
+func (m *masterRouter) ReportVoters(ctx context.Context, in *pb.ReportVotersRequest) (*pb.ReportVotersResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.ReportVoters(ctx, in)
+}
+
+func (m *masterRouter) Resync(ctx context.Context, in *pb.ResyncRequest) (*pb.ResyncResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Resync(ctx, in)
+}
+
+func (m *masterRouter) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Heartbeat(ctx, in)
+}
+
+func (m *masterRouter) RemoveSlave(ctx context.Context, in *pb.RemoveSlaveRequest) (*pb.RemoveSlaveResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.RemoveSlave(ctx, in)
+}
+
+func (m *masterRouter) Status(ctx context.Context, in *pb.StatusRequest) (*pb.StatusResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Status(ctx, in)
+}
+
+func (m *masterRouter) UpdateAccess(ctx context.Context, in *pb.UpdateAccessRequest) (*pb.UpdateAccessResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.UpdateAccess(ctx, in)
+}
+
+func (m *masterRouter) SwitchPlayerTeam(ctx context.Context, in *pb.SwitchPlayerTeamRequest) (*pb.SwitchPlayerTeamResponse, error) {
+	srv, err := m.master(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.SwitchPlayerTeam(ctx, in)
+}
+
+type slaveRouter struct {
+	r *Registry
+}
+
+func (s *slaveRouter) slave(ctx context.Context) (pb.GameServerSlaveServer, error) {
+	game, err := s.r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if game.Slave == nil {
+		return nil, status.Error(codes.NotFound, "this process is not a slave of the game")
+	}
+	return game.Slave, nil
+}
+
+func (s *slaveRouter) ChangeAcceptingVotes(ctx context.Context, in *pb.ChangeAcceptingVotesRequest) (*pb.ChangeAcceptingVotesResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.ChangeAcceptingVotes(ctx, in)
+}
+
+func (s *slaveRouter) GetVotes(ctx context.Context, in *pb.GetVotesRequest) (*pb.GetVotesResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetVotes(ctx, in)
+}
+
+func (s *slaveRouter) UpdateMetadata(ctx context.Context, in *pb.UpdateMetadataRequest) (*pb.UpdateMetadataResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.UpdateMetadata(ctx, in)
+}
+
+func (s *slaveRouter) UpdateState(ctx context.Context, in *pb.UpdateStateRequest) (*pb.UpdateStateResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.UpdateState(ctx, in)
+}
+
+func (s *slaveRouter) UpdateTally(ctx context.Context, in *pb.UpdateTallyRequest) (*pb.UpdateTallyResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.UpdateTally(ctx, in)
+}
+
+func (s *slaveRouter) StopGame(ctx context.Context, in *pb.StopGameRequest) (*pb.StopGameResponse, error) {
+	srv, err := s.slave(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.StopGame(ctx, in)
+}
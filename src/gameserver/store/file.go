@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+)
+
+// maxLogEntrySize bounds the size of a single log entry so a corrupt size prefix is not allocated.
+const maxLogEntrySize = 64 << 20
+
+// FileStore is a Store and Leases keeping each game in a directory as a snapshot file, a log file and a lease file.
+// Log entries are written length-prefixed so a partially written final entry, e.g. after a crash, is dropped on Load.
+type FileStore struct {
+	mux sync.Mutex
+	dir string
+}
+
+// NewFileStore returns a FileStore keeping games in dir, creating it if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create game store directory: %v", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) snapshotPath(gameID string) string {
+	return filepath.Join(f.dir, gameID+".snapshot")
+}
+
+func (f *FileStore) logPath(gameID string) string {
+	return filepath.Join(f.dir, gameID+".log")
+}
+
+// Load returns the latest snapshot of the game with every logged entry applied, or nil if it was never saved.
+func (f *FileStore) Load(gameID string) (*messages.GameSnapshot, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	b, err := ioutil.ReadFile(f.snapshotPath(gameID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read snapshot: %v", err)
+	}
+	snapshot := &messages.GameSnapshot{}
+	if err := proto.Unmarshal(b, snapshot); err != nil {
+		return nil, fmt.Errorf("unable to parse snapshot: %v", err)
+	}
+	entries, err := f.readLog(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return Replay(snapshot, entries), nil
+}
+
+// readLog returns every complete entry of the game's log and truncates any partially written final entry.
+func (f *FileStore) readLog(gameID string) ([]*messages.GameLogEntry, error) {
+	file, err := os.OpenFile(f.logPath(gameID), os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open log: %v", err)
+	}
+	defer file.Close()
+
+	entries := []*messages.GameLogEntry{}
+	r := bufio.NewReader(file)
+	var good int64
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err == nil && size > maxLogEntrySize {
+			err = fmt.Errorf("log entry size %d exceeds maximum", size)
+		}
+		var b []byte
+		if err == nil {
+			b = make([]byte, size)
+			_, err = io.ReadFull(r, b)
+		}
+		entry := &messages.GameLogEntry{}
+		if err == nil {
+			err = proto.Unmarshal(b, entry)
+		}
+		if err != nil {
+			// Only the final entry can be partially written, drop it so later entries are appended after the good ones.
+			if err := file.Truncate(good); err != nil {
+				return nil, fmt.Errorf("unable to truncate partial log entry: %v", err)
+			}
+			return entries, nil
+		}
+		entries = append(entries, entry)
+		good += int64(uvarintLen(size)) + int64(size)
+	}
+}
+
+// SaveSnapshot replaces the saved game with the snapshot and clears its log.
+func (f *FileStore) SaveSnapshot(gameID string, snapshot *messages.GameSnapshot) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	b, err := proto.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("unable to marshal snapshot: %v", err)
+	}
+	tmp := f.snapshotPath(gameID) + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.snapshotPath(gameID)); err != nil {
+		return fmt.Errorf("unable to replace snapshot: %v", err)
+	}
+	// Entries left behind by a crash here are older than the snapshot and skipped by Load.
+	if err := os.Remove(f.logPath(gameID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to clear log: %v", err)
+	}
+	return nil
+}
+
+// Append adds the entry to the end of the game's log.
+func (f *FileStore) Append(gameID string, entry *messages.GameLogEntry) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	b, err := proto.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to marshal log entry: %v", err)
+	}
+	file, err := os.OpenFile(f.logPath(gameID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open log: %v", err)
+	}
+	defer file.Close()
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(b)))
+	if _, err := file.Write(append(prefix[:n], b...)); err != nil {
+		return fmt.Errorf("unable to append log entry: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync log: %v", err)
+	}
+	return nil
+}
+
+// writeFileSync writes b to the file at path and syncs it to disk.
+func writeFileSync(path string, b []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", path, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(b); err != nil {
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync %s: %v", path, err)
+	}
+	return nil
+}
+
+// uvarintLen returns the number of bytes x takes as a uvarint.
+func uvarintLen(x uint64) int {
+	b := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(b, x)
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+)
+
+const testGameID = "88888888-4444-2222-1111-000000000000"
+
+func TestFileStoreLoadMissing(t *testing.T) {
+	f := newTestFileStore(t)
+	snapshot, err := f.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot != nil {
+		t.Errorf("got snapshot %v for unsaved game; want nil", snapshot)
+	}
+}
+
+func TestFileStoreReplaysLog(t *testing.T) {
+	f := newTestFileStore(t)
+	if err := f.SaveSnapshot(testGameID, testSnapshot(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	full := testHistory(3)
+	appendTestEntries(t, f, []*messages.GameLogEntry{
+		testEntry(2, HistoryAfter(testHistory(1), 0), "s2"),
+		testEntry(3, HistoryAfter(full, 1), "s3"),
+	})
+
+	got, err := f.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetGame().GetState().GetVersion(); v != 3 {
+		t.Errorf("got state version %d; want 3", v)
+	}
+	if !proto.Equal(got.GetGame().GetHistory(), full) {
+		t.Errorf("got history %v; want %v", got.GetGame().GetHistory(), full)
+	}
+	if string(got.GetSecret()) != "s3" {
+		t.Errorf("got secret %q; want s3", got.GetSecret())
+	}
+	if got.GetGame().GetMetadata().GetTitle() != "testTitle" {
+		t.Errorf("snapshot metadata lost: %v", got.GetGame().GetMetadata())
+	}
+}
+
+func TestFileStoreDropsTornEntry(t *testing.T) {
+	f := newTestFileStore(t)
+	if err := f.SaveSnapshot(testGameID, testSnapshot(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	appendTestEntries(t, f, []*messages.GameLogEntry{testEntry(2, nil, "")})
+
+	// Simulate a crash part way through appending an entry.
+	logFile, err := os.OpenFile(f.logPath(testGameID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logFile.Write([]byte{50, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	logFile.Close()
+
+	got, err := f.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetGame().GetState().GetVersion(); v != 2 {
+		t.Errorf("got state version %d; want 2", v)
+	}
+
+	// Entries appended after the torn one must still be read.
+	appendTestEntries(t, f, []*messages.GameLogEntry{testEntry(3, nil, "")})
+	if got, err = f.Load(testGameID); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetGame().GetState().GetVersion(); v != 3 {
+		t.Errorf("got state version %d after appending to truncated log; want 3", v)
+	}
+}
+
+func TestFileStoreSnapshotClearsLog(t *testing.T) {
+	f := newTestFileStore(t)
+	if err := f.SaveSnapshot(testGameID, testSnapshot(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	appendTestEntries(t, f, []*messages.GameLogEntry{testEntry(2, nil, ""), testEntry(3, nil, "")})
+	if err := f.SaveSnapshot(testGameID, testSnapshot(5, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.logPath(testGameID)); !os.IsNotExist(err) {
+		t.Errorf("log not cleared by snapshot: %v", err)
+	}
+
+	got, err := f.Load(testGameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetGame().GetState().GetVersion(); v != 5 {
+		t.Errorf("got state version %d; want 5", v)
+	}
+	if n := HistoryLen(got.GetGame().GetHistory()); n != 2 {
+		t.Errorf("got %d rounds of history; want 2", n)
+	}
+}
+
+func TestReplaySkipsStaleEntries(t *testing.T) {
+	snapshot := testSnapshot(5, 2)
+	got := Replay(snapshot, []*messages.GameLogEntry{
+		testEntry(4, HistoryAfter(testHistory(2), 1), "old"),
+		testEntry(5, nil, "old"),
+		testEntry(6, HistoryAfter(testHistory(3), 2), "new"),
+	})
+	if v := got.GetGame().GetState().GetVersion(); v != 6 {
+		t.Errorf("got state version %d; want 6", v)
+	}
+	if !proto.Equal(got.GetGame().GetHistory(), testHistory(3)) {
+		t.Errorf("got history %v; want %v", got.GetGame().GetHistory(), testHistory(3))
+	}
+	if string(got.GetSecret()) != "new" {
+		t.Errorf("got secret %q; want new", got.GetSecret())
+	}
+	if v := snapshot.GetGame().GetState().GetVersion(); v != 5 {
+		t.Errorf("Replay modified the passed snapshot, got version %d; want 5", v)
+	}
+}
+
+func TestReplayAppliesVotes(t *testing.T) {
+	snapshot := testSnapshot(5, 2)
+	snapshot.VoteSeq = 10
+	got := Replay(snapshot, []*messages.GameLogEntry{
+		testVoteEntry(5, 9, false, map[string]string{"p1": "a3"}), // Already in the snapshot.
+		testVoteEntry(5, 11, true, map[string]string{"p1": "e4", "p2": "d4"}),
+		testVoteEntry(4, 12, false, map[string]string{"p3": "e4"}), // Another round.
+		{Votes: &messages.VoteLogEntry{RoundIndex: 5, Seq: 13, RetractedPlayerIds: []string{"p2"}}},
+		testVoteEntry(5, 14, false, map[string]string{"p1": "Nf3"}),
+	})
+	s := got.GetGame().GetState().GetChessState()
+	if m := s.GetDetails().GetPlayerToMove(); len(m) != 1 || m["p1"] != "Nf3" {
+		t.Errorf("got votes %v; want only p1 for Nf3", m)
+	}
+	if c := s.GetMoveToCount(); len(c) != 1 || c["Nf3"] != 1 {
+		t.Errorf("got counts %v; want Nf3 once", c)
+	}
+	if got.GetVoteSeq() != 14 {
+		t.Errorf("got vote seq %d; want 14", got.GetVoteSeq())
+	}
+	if snapshot.GetGame().GetState().GetChessState().GetDetails() != nil {
+		t.Error("Replay modified the votes of the passed snapshot")
+	}
+}
+
+func TestHistoryAfter(t *testing.T) {
+	full := testHistory(3)
+	full.GetChessHistory().GameResult = &games.ChessGameResult{}
+	for n := 0; n <= 4; n++ {
+		after := HistoryAfter(full, n)
+		want := n
+		if want > 3 {
+			want = 3
+		}
+		merged := testHistory(want)
+		proto.Merge(merged, after)
+		if !proto.Equal(merged, full) {
+			t.Errorf("HistoryAfter(%d) merged into first %d rounds got %v; want %v", n, want, merged, full)
+		}
+	}
+	if HistoryAfter(nil, 0) != nil {
+		t.Error("HistoryAfter of empty history was not nil")
+	}
+}
+
+func newTestFileStore(t *testing.T) *FileStore {
+	dir, err := ioutil.TempDir("", "gamestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	f, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func appendTestEntries(t *testing.T, f *FileStore, entries []*messages.GameLogEntry) {
+	for _, e := range entries {
+		if err := f.Append(testGameID, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testSnapshot returns a snapshot at the state version with the first rounds of history.
+func testSnapshot(version int64, rounds int) *messages.GameSnapshot {
+	return &messages.GameSnapshot{
+		Game: &messages.Game{
+			Id:       testGameID,
+			Metadata: &messages.Game_Metadata{Title: "testTitle"},
+			State:    testState(version),
+			History:  testHistory(rounds),
+		},
+	}
+}
+
+func testEntry(version int64, history *messages.Game_History, secret string) *messages.GameLogEntry {
+	e := &messages.GameLogEntry{
+		State:   testState(version),
+		History: history,
+	}
+	if secret != "" {
+		e.Secret = []byte(secret)
+	}
+	return e
+}
+
+func testVoteEntry(round int32, seq int64, full bool, playerToMove map[string]string) *messages.GameLogEntry {
+	e := &messages.VoteLogEntry{RoundIndex: round, Seq: seq, Full: full}
+	for p, m := range playerToMove {
+		e.Votes = append(e.Votes, &messages.Vote{
+			PlayerId: p,
+			GameVote: &messages.Vote_ChessVote{ChessVote: &games.ChessVote{RoundIndex: round, Move: m}},
+		})
+	}
+	return &messages.GameLogEntry{Votes: e}
+}
+
+func testState(version int64) *messages.Game_State {
+	return &messages.Game_State{
+		Version: version,
+		Game: &messages.Game_State_ChessState{
+			ChessState: &games.ChessState{RoundIndex: int32(version)},
+		},
+	}
+}
+
+// testHistory returns a chess history of the rounds, each identified by its index.
+func testHistory(rounds int) *messages.Game_History {
+	states := []*games.ChessState{}
+	for r := 0; r < rounds; r++ {
+		states = append(states, &games.ChessState{RoundIndex: int32(r)})
+	}
+	return &messages.Game_History{
+		Game: &messages.Game_History_ChessHistory{
+			ChessHistory: &games.ChessHistory{StateHistory: states},
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+)
+
+const (
+	// leaseLockWait is how long to wait for another process to finish changing a lease.
+	leaseLockWait = time.Second
+	// leaseLockStaleAfter is how old a lease lock file may get before it is considered left behind by a crash.
+	leaseLockStaleAfter = 5 * time.Second
+)
+
+// Leases elects a single leader out of several masters running the same game.
+type Leases interface {
+	// AcquireLease makes lease's holder the game's leader until lease's expire time, if the current lease has expired
+	// or is already held by the same holder. Returns the game's lease after the attempt.
+	AcquireLease(gameID string, lease *messages.GameLease) (*messages.GameLease, error)
+
+	// ReleaseLease gives up the game's lease if held by holderID so another master can take over without waiting
+	// for it to expire.
+	ReleaseLease(gameID, holderID string) error
+}
+
+func (f *FileStore) leasePath(gameID string) string {
+	return filepath.Join(f.dir, gameID+".lease")
+}
+
+// AcquireLease makes lease's holder the game's leader until lease's expire time, if the current lease has expired
+// or is already held by the same holder. Returns the game's lease after the attempt.
+func (f *FileStore) AcquireLease(gameID string, lease *messages.GameLease) (*messages.GameLease, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lockLease(gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := f.readLease(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.GetHolderId() != lease.GetHolderId() && time.Now().UnixNano() < current.GetExpireTime() {
+		return current, nil
+	}
+	b, err := proto.Marshal(lease)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal lease: %v", err)
+	}
+	tmp := f.leasePath(gameID) + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, f.leasePath(gameID)); err != nil {
+		return nil, fmt.Errorf("unable to replace lease: %v", err)
+	}
+	return proto.Clone(lease).(*messages.GameLease), nil
+}
+
+// ReleaseLease gives up the game's lease if held by holderID so another master can take over without waiting
+// for it to expire.
+func (f *FileStore) ReleaseLease(gameID, holderID string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	unlock, err := f.lockLease(gameID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := f.readLease(gameID)
+	if err != nil {
+		return err
+	}
+	if current.GetHolderId() != holderID {
+		return nil
+	}
+	if err := os.Remove(f.leasePath(gameID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove lease: %v", err)
+	}
+	return nil
+}
+
+// readLease returns the game's lease, or nil if there is none.
+func (f *FileStore) readLease(gameID string) (*messages.GameLease, error) {
+	b, err := ioutil.ReadFile(f.leasePath(gameID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read lease: %v", err)
+	}
+	lease := &messages.GameLease{}
+	if err := proto.Unmarshal(b, lease); err != nil {
+		return nil, fmt.Errorf("unable to parse lease: %v", err)
+	}
+	return lease, nil
+}
+
+// lockLease creates a lock file so only one process at a time changes the game's lease and returns the function
+// removing it. Lock files left behind by a crashed process are removed once stale.
+func (f *FileStore) lockLease(gameID string) (func(), error) {
+	path := f.leasePath(gameID) + ".lock"
+	deadline := time.Now().Add(leaseLockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to lock lease: %v", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > leaseLockStaleAfter {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lease lock %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+)
+
+func TestAcquireLease(t *testing.T) {
+	f := newTestFileStore(t)
+	now := time.Now()
+
+	got, err := f.AcquireLease(testGameID, testLease("m1", now.Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m1" {
+		t.Fatalf("got lease held by %q; want free lease acquired by m1", got.GetHolderId())
+	}
+
+	if got, err = f.AcquireLease(testGameID, testLease("m2", now.Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m1" {
+		t.Errorf("got lease held by %q; want m1 to keep its unexpired lease", got.GetHolderId())
+	}
+
+	renewed := now.Add(2 * time.Minute).UnixNano()
+	if got, err = f.AcquireLease(testGameID, testLease("m1", time.Unix(0, renewed))); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m1" || got.GetExpireTime() != renewed {
+		t.Errorf("got lease %v; want m1 to renew its lease until %d", got, renewed)
+	}
+}
+
+func TestAcquireExpiredLease(t *testing.T) {
+	f := newTestFileStore(t)
+	if _, err := f.AcquireLease(testGameID, testLease("m1", time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.AcquireLease(testGameID, testLease("m2", time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m2" {
+		t.Errorf("got lease held by %q; want m2 to take over the expired lease", got.GetHolderId())
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	f := newTestFileStore(t)
+	if _, err := f.AcquireLease(testGameID, testLease("m1", time.Now().Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ReleaseLease(testGameID, "m2"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.AcquireLease(testGameID, testLease("m2", time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m1" {
+		t.Fatalf("got lease held by %q; want m1 to keep the lease after another master released it", got.GetHolderId())
+	}
+
+	if err := f.ReleaseLease(testGameID, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = f.AcquireLease(testGameID, testLease("m2", time.Now().Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetHolderId() != "m2" {
+		t.Errorf("got lease held by %q; want m2 to acquire the released lease", got.GetHolderId())
+	}
+}
+
+func TestLeaseLockLeftByCrash(t *testing.T) {
+	f := newTestFileStore(t)
+	lock := f.leasePath(testGameID) + ".lock"
+	if err := writeFileSync(lock, nil); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * leaseLockStaleAfter)
+	if err := os.Chtimes(lock, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.AcquireLease(testGameID, testLease("m1", time.Now().Add(time.Minute))); err != nil {
+		t.Errorf("unable to acquire lease with stale lock: %v", err)
+	}
+}
+
+func testLease(holder string, expire time.Time) *messages.GameLease {
+	return &messages.GameLease{
+		HolderId:   holder,
+		Address:    holder + ":8090",
+		ExpireTime: expire.UnixNano(),
+	}
+}
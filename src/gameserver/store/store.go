@@ -0,0 +1,112 @@
+// Package store defines how a GameServerMaster persists its game so it can recover after a restart.
+package store
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/sambdavidson/community-chess/src/proto/messages"
+	"github.com/sambdavidson/community-chess/src/proto/messages/games"
+)
+
+// Store persists games as a snapshot followed by an append-only log of changes.
+type Store interface {
+	// Load returns the latest snapshot of the game with every logged entry applied, or nil if it was never saved.
+	Load(gameID string) (*messages.GameSnapshot, error)
+
+	// SaveSnapshot replaces the saved game with the snapshot and clears its log.
+	SaveSnapshot(gameID string, snapshot *messages.GameSnapshot) error
+
+	// Append adds the entry to the end of the game's log.
+	Append(gameID string, entry *messages.GameLogEntry) error
+}
+
+// Replay applies the log entries to the snapshot in order. Entries already included in the snapshot, i.e. with a state
+// version no newer than the snapshot's or with votes no newer than the snapshot's, are skipped.
+func Replay(snapshot *messages.GameSnapshot, entries []*messages.GameLogEntry) *messages.GameSnapshot {
+	out := proto.Clone(snapshot).(*messages.GameSnapshot)
+	if out.GetGame() == nil {
+		out.Game = &messages.Game{}
+	}
+	for _, e := range entries {
+		if v := e.GetVotes(); v != nil {
+			if v.GetSeq() > out.GetVoteSeq() && applyVotes(out.GetGame().GetState(), v) {
+				out.VoteSeq = v.GetSeq()
+			}
+			continue
+		}
+		if e.GetState().GetVersion() <= out.GetGame().GetState().GetVersion() {
+			continue
+		}
+		out.VoteSeq = e.GetVoteSeq()
+		out.Game.State = proto.Clone(e.GetState()).(*messages.Game_State)
+		if e.GetHistory() != nil {
+			if out.Game.History == nil {
+				out.Game.History = &messages.Game_History{}
+			}
+			proto.Merge(out.Game.History, e.GetHistory())
+		}
+		out.Secret = e.GetSecret()
+		out.Access = e.GetAccess()
+	}
+	return out
+}
+
+// applyVotes applies the logged votes to the votes in the details of the state. Returns false if the votes are for
+// another round than the state's.
+func applyVotes(state *messages.Game_State, e *messages.VoteLogEntry) bool {
+	switch g := state.GetGame().(type) {
+	case *messages.Game_State_ChessState:
+		s := g.ChessState
+		if s.GetRoundIndex() != e.GetRoundIndex() {
+			return false
+		}
+		if s.Details == nil {
+			s.Details = &games.ChessState_Details{}
+		}
+		if e.GetFull() || s.Details.PlayerToMove == nil {
+			s.Details.PlayerToMove = map[string]string{}
+		}
+		for _, p := range e.GetRetractedPlayerIds() {
+			delete(s.Details.PlayerToMove, p)
+		}
+		for _, v := range e.GetVotes() {
+			s.Details.PlayerToMove[v.GetPlayerId()] = v.GetChessVote().GetMove()
+		}
+		// The count of a detailed state is of the votes in its details.
+		s.MoveToCount = make(map[string]int64, len(s.Details.PlayerToMove))
+		for _, m := range s.Details.PlayerToMove {
+			s.MoveToCount[m]++
+		}
+		return true
+	}
+	return false
+}
+
+// HistoryLen returns the number of closed rounds in the history.
+func HistoryLen(h *messages.Game_History) int {
+	switch g := h.GetGame().(type) {
+	case *messages.Game_History_ChessHistory:
+		return len(g.ChessHistory.GetStateHistory())
+	}
+	return 0
+}
+
+// HistoryAfter returns a history holding only the rounds closed after the first n, and the game's result if any.
+// Merging it into a history of the first n rounds gives the full history.
+func HistoryAfter(h *messages.Game_History, n int) *messages.Game_History {
+	switch g := h.GetGame().(type) {
+	case *messages.Game_History_ChessHistory:
+		rounds := g.ChessHistory.GetStateHistory()
+		if n > len(rounds) {
+			n = len(rounds)
+		}
+		return &messages.Game_History{
+			Game: &messages.Game_History_ChessHistory{
+				ChessHistory: &games.ChessHistory{
+					StateHistory: rounds[n:],
+					GameResult:   g.ChessHistory.GetGameResult(),
+				},
+			},
+		}
+	}
+	return nil
+}
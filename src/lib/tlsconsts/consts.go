@@ -43,6 +43,7 @@ const (
 	GameMaster      SAN = "gamemaster"
 	GameSlave       SAN = "gameslave"
 	PlayerRegistrar SAN = "playerregistrar"
+	GameRegistrar   SAN = "gameregistrar"
 	Admin           SAN = "admin"
 	Internal        SAN = "internal"
 )
@@ -37,10 +37,21 @@ func GameMetadata(m *messages.Game_Metadata) error {
 		if r.GetVoteAppliedAfterTally().GetTimeoutSeconds() < 3 {
 			return status.Errorf(codes.InvalidArgument, "timeout too short, must be 3 or more seconds")
 		}
-	} else if r.GetVoteAppliedImmediately() == nil {
+	} else if r.GetVoteAppliedImmediately() != nil {
+		if r.GetVoteAppliedImmediately().GetCooldownSeconds() < 0 {
+			return status.Errorf(codes.InvalidArgument, "cooldown cannot be negative")
+		}
+	} else {
 		return status.Errorf(codes.InvalidArgument, "missing vote application oneof")
 	}
 
+	if r.GetVoteChanges() == messages.Game_Metadata_Rules_LOCKED_VOTES && r.GetVoteLockSeconds() <= 0 {
+		return status.Errorf(codes.InvalidArgument, "vote lock must be 1 or more seconds when votes are locked")
+	}
+	if r.GetVoteLockSeconds() < 0 {
+		return status.Errorf(codes.InvalidArgument, "vote lock cannot be negative")
+	}
+
 	if r.GetGameSpecific() == nil {
 		return status.Errorf(codes.InvalidArgument, "missing game specific rules")
 	}